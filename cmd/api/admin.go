@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/9thDuck/chat_go.git/internal/store"
+)
+
+type updateUserRolePayload struct {
+	Role string `json:"role" validate:"required,oneof=user moderator admin"`
+}
+
+// updateUserRoleHandler lets an admin change the role of another user. Admins
+// cannot change their own role so there is always one left.
+func (app *application) updateUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	admin := getUserFromCtx(r)
+	userID := getUserIDParamFromCtx(r)
+
+	if userID == admin.ID {
+		app.badRequestError(w, r, nil, "you cannot change your own role")
+		return
+	}
+
+	var payload updateUserRolePayload
+	if err := readJson(w, r, &payload); err != nil {
+		app.badRequestError(w, r, err, "role must be one of the following: user, moderator, admin")
+		return
+	}
+	if err := Validate.Struct(&payload); err != nil {
+		app.badRequestError(w, r, err, "role must be one of the following: user, moderator, admin")
+		return
+	}
+
+	ctx := r.Context()
+
+	role, err := app.store.Roles.GetByName(ctx, payload.Role)
+	if err != nil {
+		app.internalError(w, r, err)
+		return
+	}
+
+	switch err := app.store.Users.UpdateRole(ctx, userID, role.ID); err {
+	case nil:
+	case store.ErrNotFound:
+		app.notFoundError(w, r, err, "user not found")
+		return
+	default:
+		app.internalError(w, r, err)
+		return
+	}
+
+	if app.config.cacheCfg.initialised {
+		if err := app.cache.Users.Delete(ctx, userID); err != nil {
+			app.internalError(w, r, err)
+			return
+		}
+	}
+
+	user, err := app.getUser(ctx, userID)
+	if err != nil {
+		app.internalError(w, r, err)
+		return
+	}
+
+	app.jsonResponse(w, http.StatusOK, user)
+}
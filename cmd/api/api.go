@@ -1,7 +1,12 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"expvar"
 	"net/http"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/9thDuck/chat_go.git/cmd/api/ws"
@@ -22,6 +27,11 @@ type application struct {
 	logger        *zap.SugaredLogger
 	authenticator auth.Authenticator
 	socketHub     *ws.Hub
+	backplane     ws.Backplane
+	presence      ws.PresenceStore
+	events        ws.EventLog
+	tickets       ws.TicketStore
+	typing        *typingIndicators
 	cloud         *cloudStorage.CloudStorage
 }
 
@@ -39,7 +49,7 @@ func (app *application) mount() http.Handler {
 		AllowedOrigins: []string{"https://*", "http://*"},
 		// AllowOriginFunc:  func(r *http.Request, origin string) bool { return true },
 		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "Idempotency-Key"},
 		ExposedHeaders:   []string{"Link"},
 		AllowCredentials: true,
 		MaxAge:           300, //
@@ -76,9 +86,11 @@ func (app *application) mount() http.Handler {
 			r.Use(app.ValidateTokenMiddleware())
 			r.With(app.paginationMiddleware).Get("/", app.getContactsHandler)
 			r.With(app.paginationMiddleware).Get("/search", app.searchContactsHandler)
+			r.Get("/presence", app.getContactsPresenceHandler)
 			r.Route("/{contactID}", func(r chi.Router) {
 				r.Use(app.getContactIDParamMiddleware)
 				r.Delete("/", app.deleteContactHandler)
+				r.Put("/timer", app.setContactMessageTimerHandler)
 			})
 			// requests
 			r.Route("/requests", func(r chi.Router) {
@@ -96,20 +108,72 @@ func (app *application) mount() http.Handler {
 		r.Route("/messages", func(r chi.Router) {
 			r.Use(app.ValidateTokenMiddleware())
 			r.With(app.paginationMiddleware).Get("/", app.getMessagesHandler)
-			r.Route("/{receiverID}", func(r chi.Router) {
-				r.Use(app.getReceiverIDParamMiddleware)
-				r.With(app.preMessageCreationMiddleware).Post("/", app.createMessageHandler)
+			r.Post("/ack", app.ackMessagesHandler)
+			r.Post("/read", app.markMessagesReadHandler)
+			r.Get("/tombstones", app.getMessageTombstonesHandler)
+			r.Route("/scheduled", func(r chi.Router) {
+				r.With(app.paginationMiddleware).Get("/", app.getScheduledMessagesHandler)
+				r.With(app.getMessageIDParamMiddleware).Patch("/{id}", app.editScheduledMessageHandler)
+				r.With(app.getMessageIDParamMiddleware).Delete("/{id}", app.cancelScheduledMessageHandler)
+			})
+			// id is the receiver when sending and the message otherwise, chi
+			// cannot route sibling params with different names.
+			r.Route("/{id}", func(r chi.Router) {
+				r.With(app.getReceiverIDParamMiddleware, app.preMessageCreationMiddleware).Post("/", app.createMessageHandler)
+				r.With(app.getMessageIDParamMiddleware).Patch("/", app.editMessageHandler)
+				r.With(app.getMessageIDParamMiddleware).Delete("/", app.unsendMessageHandler)
+				r.With(app.getMessageIDParamMiddleware).Get("/versions", app.getMessageVersionsHandler)
+				r.With(app.getMessageIDParamMiddleware).Post("/reactions", app.addReactionHandler)
+				r.With(app.getMessageIDParamMiddleware).Delete("/reactions/{emoji}", app.removeReactionHandler)
 			})
 		})
 
-		r.Route("/ws", func(r chi.Router) {
+		r.With(app.ValidateTokenMiddleware(), app.paginationMiddleware).Get("/conversations", app.getConversationsHandler)
+
+		r.Route("/rooms", func(r chi.Router) {
 			r.Use(app.ValidateTokenMiddleware())
-			r.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			r.With(app.paginationMiddleware).Get("/", app.getRoomsHandler)
+			r.Post("/", app.createRoomHandler)
+			r.Route("/{roomID}", func(r chi.Router) {
+				r.Use(app.roomMemberMiddleware)
+				r.Get("/", app.getRoomHandler)
+				r.With(app.requireRoomRole(store.ROOM_ROLE_OWNER)).Patch("/", app.updateRoomHandler)
+				r.With(app.requireRoomRole(store.ROOM_ROLE_OWNER)).Delete("/", app.deleteRoomHandler)
+				r.With(app.requireRoomRole(store.ROOM_ROLE_OWNER)).Post("/members", app.addRoomMemberHandler)
+				r.With(app.requireRoomRole(store.ROOM_ROLE_OWNER)).Put("/timer", app.setRoomMessageTimerHandler)
+				r.Route("/members/{userID}", func(r chi.Router) {
+					r.Use(app.getUserIDParamMiddleware)
+					r.Delete("/", app.removeRoomMemberHandler)
+					r.With(app.requireRoomRole(store.ROOM_ROLE_OWNER)).Patch("/role", app.updateRoomMemberRoleHandler)
+					r.With(app.requireRoomRole(store.ROOM_ROLE_MODERATOR)).Put("/mute", app.muteRoomMemberHandler)
+					r.With(app.requireRoomRole(store.ROOM_ROLE_MODERATOR)).Delete("/mute", app.unmuteRoomMemberHandler)
+				})
+				r.With(app.paginationMiddleware).Get("/messages", app.getRoomMessagesHandler)
+				r.Post("/messages", app.createRoomMessageHandler)
+				r.With(app.requireRoomRole(store.ROOM_ROLE_MODERATOR), app.getMessageIDParamMiddleware).Delete("/messages/{id}", app.deleteRoomMessageHandler)
+			})
+		})
+
+		r.Route("/ws", func(r chi.Router) {
+			r.With(app.ValidateTokenMiddleware()).Post("/ticket", app.createSocketTicketHandler)
+			r.With(app.socketAuthMiddleware).HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 				userID := getUserFromCtx(r).ID
 				ws.Serve(w, r, app.socketHub, userID)
 			})
 		})
 
+		r.With(app.ValidateTokenMiddleware()).Get("/events", func(w http.ResponseWriter, r *http.Request) {
+			userID := getUserFromCtx(r).ID
+			ws.ServeSSE(w, r, app.socketHub, userID)
+		})
+
+		r.With(app.ValidateTokenMiddleware(), app.requireRole(store.ROLE_ADMIN)).Get("/debug/vars", expvar.Handler().ServeHTTP)
+
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(app.ValidateTokenMiddleware(), app.requireRole(store.ROLE_ADMIN))
+			r.With(app.getUserIDParamMiddleware).Patch("/users/{userID}/role", app.updateUserRoleHandler)
+		})
+
 		r.Route("/cloud", func(r chi.Router) {
 			r.Use(app.ValidateTokenMiddleware())
 			r.Route("/presignedurl", func(r chi.Router) {
@@ -129,9 +193,46 @@ func (app *application) run(handler http.Handler) error {
 		IdleTimeout:  time.Minute,
 		Handler:      handler,
 	}
-	app.socketHub = ws.NewHub()
-	go app.socketHub.Run()
-	app.logger.Infow("Server listening", "port", app.config.addr)
+	app.socketHub = ws.NewHub(app.socketDispatcher(), app.backplane, app.presence, app.events)
+	srv.RegisterOnShutdown(app.socketHub.CloseStreams)
+	expvar.Publish("ws_hub", expvar.Func(func() any { return app.socketHub.Stats() }))
+
+	hubCtx, stopHub := context.WithCancel(context.Background())
+	defer stopHub()
+	go app.socketHub.Run(hubCtx)
+	go app.runScheduledMessageDispatcher(hubCtx)
+	go app.runMessageReaper(hubCtx)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		app.logger.Infow("Server listening", "port", app.config.addr)
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	case <-ctx.Done():
+	}
+
+	app.logger.Infow("Shutting down server", "timeout", app.config.shutdownTimeout.String())
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), app.config.shutdownTimeout)
+	defer cancel()
+
+	// Stop accepting requests and let in-flight ones, message transactions
+	// included, finish before the sockets they may push to are closed. The
+	// sockets are closed even when requests are still running at the timeout.
+	err := errors.Join(srv.Shutdown(shutdownCtx), app.socketHub.Shutdown(shutdownCtx))
+	if err != nil {
+		return err
+	}
 
-	return srv.ListenAndServe()
+	app.logger.Infow("Server stopped")
+	return nil
 }
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"time"
+
 	"github.com/9thDuck/chat_go.git/internal/auth"
 	"github.com/9thDuck/chat_go.git/internal/store/cache"
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -43,6 +45,29 @@ type s3Cfg struct {
 type cloudCfg struct {
 	s3 s3Cfg
 }
+type hubCfg struct {
+	// redisBackplane relays socket events between API instances through
+	// redis pub/sub, it is required when running more than one instance.
+	redisBackplane bool
+	nodeID         string
+	// allowedOrigins are the browser origins that may open a socket.
+	allowedOrigins []string
+}
+
+type messagesCfg struct {
+	// unsendWindow is how long after sending a message its sender may still
+	// delete it for everyone.
+	unsendWindow time.Duration
+	// scheduleInterval is how often scheduled messages that are due get
+	// released.
+	scheduleInterval time.Duration
+	// reapInterval is how often expired messages get purged.
+	reapInterval time.Duration
+	// undeliveredRetention is how long a direct message waits for its
+	// receiver to connect before it is purged.
+	undeliveredRetention time.Duration
+}
+
 type config struct {
 	appName  string
 	addr     string
@@ -51,4 +76,9 @@ type config struct {
 	cloud    cloudCfg
 	auth     authConfig
 	cacheCfg cacheCfg
+	hub      hubCfg
+	messages messagesCfg
+	// shutdownTimeout bounds how long in-flight requests and open sockets
+	// get to finish once a SIGINT or SIGTERM is received.
+	shutdownTimeout time.Duration
 }
@@ -3,6 +3,7 @@ package main
 import (
 	"net/http"
 
+	"github.com/9thDuck/chat_go.git/cmd/api/ws"
 	"github.com/9thDuck/chat_go.git/internal/store"
 )
 
@@ -17,12 +18,12 @@ func (app *application) getContactRequestByIDHandler(w http.ResponseWriter, r *h
 
 	pagination := getPaginationOptionsFromCtx(r)
 
-	contactRequests, total, err := app.store.ContactRequests.Get(r.Context(), user.ID, pagination)
+	contactRequests, page, err := app.store.ContactRequests.Get(r.Context(), user.ID, pagination)
 	if err != nil {
 		app.internalError(w, r, err)
 		return
 	}
-	if err := app.jsonResponse(w, http.StatusOK, paginatedEnvelope{Records: &contactRequests, TotalRecords: total}); err != nil {
+	if err := app.jsonResponse(w, http.StatusOK, newCursorEnvelope(contactRequests, page)); err != nil {
 		app.internalError(w, r, err)
 		return
 	}
@@ -30,7 +31,7 @@ func (app *application) getContactRequestByIDHandler(w http.ResponseWriter, r *h
 
 func (app *application) createContactRequestHandler(w http.ResponseWriter, r *http.Request) {
 	user := getUserFromCtx(r)
-	
+
 	contactID := getContactIDFromCtx(r)
 
 	var payload CreateContactRequestRequest
@@ -38,30 +39,39 @@ func (app *application) createContactRequestHandler(w http.ResponseWriter, r *ht
 		app.badRequestError(w, r, err, "")
 		return
 	}
-	
+
 	if err := Validate.Struct(payload); err != nil {
 		app.badRequestError(w, r, err, "")
 		return
 	}
 
-	err := app.store.ContactRequests.Create(r.Context(), user.ID, contactID)
+	contactRequest, err := app.store.ContactRequests.Create(r.Context(), user.ID, contactID)
 	switch err {
+	case nil:
 	case store.ErrContactRequestAlreadyExists:
 		app.badRequestError(w, r, err, "")
 		return
 	case store.ErrContactRequestForeignKeyViolation:
 		app.notFoundError(w, r, err, "")
 		return
+	default:
+		app.internalError(w, r, err)
+		return
 	}
 
 	err = app.store.Messages.Create(r.Context(), &store.Message{
-		SenderID: user.ID,
+		SenderID:   user.ID,
 		ReceiverID: contactID,
-		Content: payload.Message,
+		Content:    payload.Message,
 	})
 
 	switch err {
 	case nil:
+		contactRequest.MessageContent = payload.Message
+		app.socketHub.WriteToClient(contactID, &ws.Event{
+			Type: ws.EVENT_CONTACT_REQUEST_RECEIVED,
+			Data: contactRequest,
+		})
 		app.jsonResponse(w, http.StatusNoContent, nil)
 		return
 	default:
@@ -81,10 +91,12 @@ func (app *application) updateContactRequestHandler(w http.ResponseWriter, r *ht
 	user := getUserFromCtx(r)
 	contactID := getContactIDFromCtx(r)
 
+	var contactRequest *store.ContactRequest
 	var err error
+	eventType := ws.EVENT_CONTACT_REQUEST_ACCEPTED
 	switch operation {
 	case "accept":
-		err = app.store.ContactRequests.Accept(r.Context(), contactID, user.ID)
+		contactRequest, err = app.store.ContactRequests.Accept(r.Context(), contactID, user.ID)
 		if err == nil && app.config.cacheCfg.initialised {
 			err = app.cache.Contacts.SetContactExists(r.Context(), user.ID, contactID, true)
 			if err != nil {
@@ -92,11 +104,13 @@ func (app *application) updateContactRequestHandler(w http.ResponseWriter, r *ht
 			}
 		}
 	case "reject":
-		err = app.store.ContactRequests.Reject(r.Context(), contactID, user.ID)
+		contactRequest, err = app.store.ContactRequests.Reject(r.Context(), contactID, user.ID)
+		eventType = ws.EVENT_CONTACT_REQUEST_REJECTED
 	}
 
 	switch err {
 	case nil:
+		app.socketHub.WriteToClient(contactID, &ws.Event{Type: eventType, Data: contactRequest})
 		w.WriteHeader(http.StatusNoContent)
 		return
 	case store.ErrContactRequestNotFound:
@@ -115,9 +129,13 @@ func (app *application) deleteContactRequestHandler(w http.ResponseWriter, r *ht
 	user := getUserFromCtx(r)
 	contactID := getContactIDFromCtx(r)
 
-	err := app.store.ContactRequests.Delete(r.Context(), user.ID, contactID)
+	contactRequest, err := app.store.ContactRequests.Delete(r.Context(), user.ID, contactID)
 	switch err {
 	case nil:
+		app.socketHub.WriteToClient(contactID, &ws.Event{
+			Type: ws.EVENT_CONTACT_REQUEST_WITHDRAWN,
+			Data: contactRequest,
+		})
 		w.WriteHeader(http.StatusNoContent)
 	case store.ErrContactRequestNotFound:
 		app.notFoundError(w, r, err, "")
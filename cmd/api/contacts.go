@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
 	"net/http"
 	"strings"
 
+	"github.com/9thDuck/chat_go.git/cmd/api/ws"
+	"github.com/9thDuck/chat_go.git/internal/domain"
 	"github.com/9thDuck/chat_go.git/internal/store"
 )
 
@@ -11,7 +14,7 @@ func (app *application) getContactsHandler(w http.ResponseWriter, r *http.Reques
 	user := getUserFromCtx(r)
 	pagination := getPaginationOptionsFromCtx(r)
 
-	contactIDsSlice, total, err := app.store.Contacts.Get(r.Context(), user.ID, pagination)
+	contactIDsSlice, page, err := app.store.Contacts.Get(r.Context(), user.ID, pagination)
 	if err != nil {
 		app.internalError(w, r, err)
 		return
@@ -27,7 +30,7 @@ func (app *application) getContactsHandler(w http.ResponseWriter, r *http.Reques
 		contactsAsUsers[i] = *user
 	}
 
-	app.jsonResponse(w, http.StatusOK, paginatedEnvelope{Records: &contactsAsUsers, TotalRecords: total})
+	app.jsonResponse(w, http.StatusOK, newCursorEnvelope(&contactsAsUsers, page))
 }
 
 func (app *application) deleteContactHandler(w http.ResponseWriter, r *http.Request) {
@@ -43,6 +46,17 @@ func (app *application) deleteContactHandler(w http.ResponseWriter, r *http.Requ
 				app.logger.Errorw("Failed to delete contact from cache", "error", err)
 			}
 		}
+		app.socketHub.WriteToClient(contactID, &ws.Event{
+			Type: ws.EVENT_CONTACT_REMOVED,
+			Data: domain.PublicProfile{
+				ID:         user.ID,
+				Username:   user.Username,
+				PublicKey:  user.PublicKey,
+				FirstName:  user.FirstName,
+				LastName:   user.LastName,
+				ProfilePic: user.ProfilePic,
+			},
+		})
 		w.WriteHeader(http.StatusNoContent)
 		return
 	case store.ErrContactNotFound:
@@ -71,7 +85,7 @@ func (app *application) searchContactsHandler(w http.ResponseWriter, r *http.Req
 	}
 
 	pagination := getPaginationOptionsFromCtx(r)
-	contactIDSlice, total, err := app.store.Contacts.Search(r.Context(), user.ID, searchTerm, pagination)
+	contactIDSlice, page, err := app.store.Contacts.Search(r.Context(), user.ID, searchTerm, pagination)
 	if err != nil {
 		app.internalError(w, r, err)
 		return
@@ -85,5 +99,38 @@ func (app *application) searchContactsHandler(w http.ResponseWriter, r *http.Req
 		}
 		contactsAsUsers[i] = *user
 	}
-	app.jsonResponse(w, http.StatusOK, paginatedEnvelope{Records: &contactsAsUsers, TotalRecords: total})
+	app.jsonResponse(w, http.StatusOK, newCursorEnvelope(&contactsAsUsers, page))
+}
+
+func (app *application) getContactsPresenceHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+
+	contactIDs, err := app.store.Contacts.GetAllIDs(r.Context(), user.ID)
+	if err != nil {
+		app.internalError(w, r, err)
+		return
+	}
+
+	presences, err := app.socketHub.Presence(r.Context(), contactIDs)
+	if err != nil {
+		app.internalError(w, r, err)
+		return
+	}
+
+	app.jsonResponse(w, http.StatusOK, presences)
+}
+
+// notifyContactsOfPresence pushes a presence change to every contact of the
+// user it is about.
+func (app *application) notifyContactsOfPresence(ctx context.Context, presence ws.Presence) {
+	contactIDs, err := app.store.Contacts.GetAllIDs(ctx, presence.UserID)
+	if err != nil {
+		app.logger.Errorw("Failed to load contacts for presence change", "userID", presence.UserID, "error", err)
+		return
+	}
+
+	event := &ws.Event{Type: ws.EVENT_PRESENCE, Data: presence}
+	for _, contactID := range contactIDs {
+		app.socketHub.WriteToClient(contactID, event)
+	}
 }
@@ -0,0 +1,19 @@
+package main
+
+import "net/http"
+
+// getConversationsHandler lists a conversation for each contact of the user,
+// so clients can tell which chats have new traffic without paging through
+// contacts and messages.
+func (app *application) getConversationsHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+	pagination := getPaginationOptionsFromCtx(r)
+
+	conversations, page, err := app.store.Conversations.Get(r.Context(), user.ID, pagination)
+	if err != nil {
+		app.internalError(w, r, err)
+		return
+	}
+
+	app.jsonResponse(w, http.StatusOK, newCursorEnvelope(conversations, page))
+}
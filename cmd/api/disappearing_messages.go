@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/9thDuck/chat_go.git/cmd/api/ws"
+	"github.com/9thDuck/chat_go.git/internal/store"
+)
+
+// expiredPurgeBatchSize is how many expired messages are purged at a time.
+const expiredPurgeBatchSize = 100
+
+// messageTimerPayload sets a disappearing message timer, 0 seconds turns it
+// off. Timers go up to 90 days.
+type messageTimerPayload struct {
+	Seconds int `json:"seconds" validate:"min=0,max=7776000"`
+}
+
+const messageTimerPayloadValidationErrMsg = "seconds must be between 0 and 7776000"
+
+func readMessageTimerPayload(w http.ResponseWriter, r *http.Request) (*messageTimerPayload, error) {
+	var payload messageTimerPayload
+	if err := readJson(w, r, &payload); err != nil {
+		return nil, err
+	}
+	if err := Validate.Struct(&payload); err != nil {
+		return nil, err
+	}
+	return &payload, nil
+}
+
+// setContactMessageTimerHandler sets the timer of the conversation with a
+// contact, for both of them.
+func (app *application) setContactMessageTimerHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+	contactID := getContactIDFromCtx(r)
+
+	payload, err := readMessageTimerPayload(w, r)
+	if err != nil {
+		app.badRequestError(w, r, err, messageTimerPayloadValidationErrMsg)
+		return
+	}
+
+	ttl := time.Duration(payload.Seconds) * time.Second
+	switch err := app.store.Contacts.SetMessageTTL(r.Context(), user.ID, contactID, ttl); err {
+	case nil:
+	case store.ErrContactNotFound:
+		app.notFoundError(w, r, err, "contact not found")
+		return
+	default:
+		app.internalError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	app.socketHub.WriteToClient(contactID, &ws.Event{
+		Type: ws.EVENT_MESSAGE_TIMER_UPDATED,
+		Data: ws.MessageTimerEventData{UserID: user.ID, Seconds: payload.Seconds},
+	})
+}
+
+func (app *application) setRoomMessageTimerHandler(w http.ResponseWriter, r *http.Request) {
+	room := getRoomFromCtx(r)
+	user := getUserFromCtx(r)
+
+	payload, err := readMessageTimerPayload(w, r)
+	if err != nil {
+		app.badRequestError(w, r, err, messageTimerPayloadValidationErrMsg)
+		return
+	}
+
+	ttl := time.Duration(payload.Seconds) * time.Second
+	switch err := app.store.Rooms.SetMessageTTL(r.Context(), room, ttl); err {
+	case nil:
+		app.jsonResponse(w, http.StatusOK, room)
+		app.pushRoomEvent(room.MemberIDs, user.ID, &ws.Event{Type: ws.EVENT_ROOM_UPDATED, Data: room})
+	case store.ErrNotFound:
+		app.notFoundError(w, r, err, "room not found")
+	default:
+		app.internalError(w, r, err)
+	}
+}
+
+// runMessageReaper purges expired messages every reapInterval until ctx is
+// done. Several nodes can run it at once, each message is purged by one of
+// them.
+func (app *application) runMessageReaper(ctx context.Context) {
+	ticker := time.NewTicker(app.config.messages.reapInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			app.purgeExpiredMessages(ctx)
+		}
+	}
+}
+
+// purgeExpiredMessages purges expired messages in batches along with their
+// stored attachments. Senders are told about the messages that expired before
+// reaching everyone.
+func (app *application) purgeExpiredMessages(ctx context.Context) {
+	for {
+		expired, paths, err := app.store.Messages.PurgeExpired(ctx, app.config.messages.undeliveredRetention, expiredPurgeBatchSize)
+		if err != nil {
+			if !errors.Is(err, context.Canceled) {
+				app.logger.Errorw("Failed to purge expired messages", "error", err)
+			}
+			return
+		}
+
+		if len(paths) > 0 {
+			if err := app.cloud.Objects.Delete(ctx, app.config.cloud.s3.bucketName, paths); err != nil {
+				app.logger.Errorw("Failed to delete attachments of expired messages", "count", len(paths), "error", err)
+			}
+		}
+
+		for i := range expired {
+			if expired[i].Undelivered {
+				app.socketHub.WriteToClient(expired[i].SenderID, &ws.Event{
+					Type: ws.EVENT_MESSAGE_EXPIRED,
+					Data: &expired[i],
+				})
+			}
+		}
+
+		if len(expired) < expiredPurgeBatchSize {
+			return
+		}
+	}
+}
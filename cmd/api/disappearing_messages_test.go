@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/9thDuck/chat_go.git/cmd/api/ws"
+	cloudStorage "github.com/9thDuck/chat_go.git/internal/cloud_storage"
+	"github.com/9thDuck/chat_go.git/internal/store"
+	"go.uber.org/zap"
+)
+
+// fakeObjectRemover records the keys it is asked to delete.
+type fakeObjectRemover struct {
+	deleted []string
+}
+
+func (r *fakeObjectRemover) Delete(ctx context.Context, bucketName string, objectKeys []string) error {
+	r.deleted = append(r.deleted, objectKeys...)
+	return nil
+}
+
+// expiringMessages hands out one purge batch per PurgeExpired call. Any other
+// store method is left to the embedded store, which has no database.
+type expiringMessages struct {
+	*store.MessagesStore
+	batches []purgeBatch
+	calls   int
+}
+
+type purgeBatch struct {
+	expired []store.ExpiredMessage
+	paths   []string
+	err     error
+}
+
+func (m *expiringMessages) PurgeExpired(ctx context.Context, retention time.Duration, limit int) ([]store.ExpiredMessage, []string, error) {
+	if m.calls == len(m.batches) {
+		return nil, nil, errors.New("purged more batches than expected")
+	}
+	batch := m.batches[m.calls]
+	m.calls++
+	return batch.expired, batch.paths, batch.err
+}
+
+// newTestApplication returns an application with a hub that is not running and
+// a store left for the test to fill in.
+func newTestApplication() (*application, *fakeObjectRemover) {
+	remover := &fakeObjectRemover{}
+	app := &application{
+		logger:    zap.NewNop().Sugar(),
+		socketHub: ws.NewHub(ws.NewDispatcher(), ws.NewLocalBackplane(), ws.NewMemoryPresenceStore(), ws.NewMemoryEventLog()),
+		cloud:     &cloudStorage.CloudStorage{Objects: remover},
+	}
+	return app, remover
+}
+
+func expiredMessages(n int) []store.ExpiredMessage {
+	expired := make([]store.ExpiredMessage, n)
+	for i := range expired {
+		expired[i] = store.ExpiredMessage{MessageID: int64(i + 1), SenderID: 1, ReceiverID: 2, Undelivered: i%2 == 0}
+	}
+	return expired
+}
+
+func TestPurgeExpiredMessages(t *testing.T) {
+	tests := []struct {
+		name      string
+		batches   []purgeBatch
+		wantPaths []string
+	}{
+		{
+			name:    "nothing expired",
+			batches: []purgeBatch{{}},
+		},
+		{
+			name:      "partial batch",
+			batches:   []purgeBatch{{expired: expiredMessages(3), paths: []string{"user-1-a"}}},
+			wantPaths: []string{"user-1-a"},
+		},
+		{
+			name: "full batches until a partial one",
+			batches: []purgeBatch{
+				{expired: expiredMessages(expiredPurgeBatchSize), paths: []string{"user-1-a"}},
+				{expired: expiredMessages(expiredPurgeBatchSize)},
+				{expired: expiredMessages(1), paths: []string{"user-1-b", "user-1-c"}},
+			},
+			wantPaths: []string{"user-1-a", "user-1-b", "user-1-c"},
+		},
+		{
+			name: "full batch then nothing",
+			batches: []purgeBatch{
+				{expired: expiredMessages(expiredPurgeBatchSize)},
+				{},
+			},
+		},
+		{
+			name: "error stops the purge",
+			batches: []purgeBatch{
+				{expired: expiredMessages(expiredPurgeBatchSize), paths: []string{"user-1-a"}},
+				{err: errors.New("connection reset")},
+			},
+			wantPaths: []string{"user-1-a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages := &expiringMessages{batches: tt.batches}
+			app, remover := newTestApplication()
+			app.store.Messages = messages
+
+			app.purgeExpiredMessages(context.Background())
+
+			if messages.calls != len(tt.batches) {
+				t.Errorf("PurgeExpired called %d times, want %d", messages.calls, len(tt.batches))
+			}
+			if !slices.Equal(remover.deleted, tt.wantPaths) {
+				t.Errorf("deleted %v, want %v", remover.deleted, tt.wantPaths)
+			}
+		})
+	}
+}
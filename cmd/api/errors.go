@@ -26,6 +26,14 @@ func (app *application) notFoundError(w http.ResponseWriter, r *http.Request, er
 	return app.writeJsonError(w, http.StatusNotFound, customErrorMsg)
 }
 
+func (app *application) conflictError(w http.ResponseWriter, r *http.Request, err error, customErrorMsg string) error {
+	app.logger.Warnw("conflict error", "path", r.URL, "method", r.Method, "error", err, "custom error message", customErrorMsg)
+	if customErrorMsg == "" {
+		customErrorMsg = err.Error()
+	}
+	return app.writeJsonError(w, http.StatusConflict, customErrorMsg)
+}
+
 func (app *application) unauthorizedError(w http.ResponseWriter, r *http.Request, err error) {
 	app.logger.Warnf("unauthorized error", "method", r.Method, "path", r.URL.Path, "error", err)
 	app.writeJsonError(w, http.StatusUnauthorized, "unauthorized")
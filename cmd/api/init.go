@@ -6,4 +6,7 @@ var Validate *validator.Validate
 
 func init() {
 	Validate = validator.New(validator.WithRequiredStructEnabled())
+	Validate.RegisterValidation("emoji", func(fl validator.FieldLevel) bool {
+		return isEmoji(fl.Field().String())
+	})
 }
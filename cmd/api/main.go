@@ -8,12 +8,14 @@ import (
 	"os"
 	"time"
 
+	"github.com/9thDuck/chat_go.git/cmd/api/ws"
 	"github.com/9thDuck/chat_go.git/internal/auth"
 	cloudStorage "github.com/9thDuck/chat_go.git/internal/cloud_storage"
 	"github.com/9thDuck/chat_go.git/internal/db"
 	"github.com/9thDuck/chat_go.git/internal/env"
 	"github.com/9thDuck/chat_go.git/internal/store"
 	"github.com/9thDuck/chat_go.git/internal/store/cache"
+	"github.com/go-redis/redis/v8"
 	"github.com/joho/godotenv"
 	"go.uber.org/zap"
 )
@@ -42,6 +44,8 @@ func main() {
 
 	}
 
+	hostname, _ := os.Hostname()
+
 	currentEnv := env.GetEnvString("ENV", "development")
 	isProduction := currentEnv == "production"
 	bucketName := env.GetEnvString("DEV_BUCKET_NAME", "")
@@ -68,8 +72,9 @@ func main() {
 						Refresh: time.Duration(env.GetEnvInt("JWT_REFRESH_TOKEN_EXPIRY_IN_DAYS", 7)) * time.Hour * 24,
 					}},
 			},
-			env:     env.GetEnvString("ENV", "development"),
-			appName: env.GetEnvString("APP_NAME", "DuckChat"),
+			env:             env.GetEnvString("ENV", "development"),
+			appName:         env.GetEnvString("APP_NAME", "DuckChat"),
+			shutdownTimeout: time.Duration(env.GetEnvInt("SHUTDOWN_TIMEOUT_SECONDS", 15)) * time.Second,
 			cacheCfg: cacheCfg{
 				redis: redisCfg{
 					enabled: env.GetBool("REDIS_CACHE_ENABLED", false),
@@ -82,6 +87,17 @@ func main() {
 					Contacts: time.Duration(env.GetEnvInt("CACHE_CONTACTS_EXPIRY_HOURS", 24)) * time.Hour,
 				},
 			},
+			hub: hubCfg{
+				redisBackplane: env.GetBool("WS_REDIS_BACKPLANE_ENABLED", false),
+				nodeID:         env.GetEnvString("NODE_ID", hostname),
+				allowedOrigins: env.GetEnvList("WS_ALLOWED_ORIGINS", nil),
+			},
+			messages: messagesCfg{
+				unsendWindow:         time.Duration(env.GetEnvInt("MESSAGE_UNSEND_WINDOW_MINUTES", 60)) * time.Minute,
+				scheduleInterval:     time.Duration(env.GetEnvInt("MESSAGE_SCHEDULE_INTERVAL_SECONDS", 10)) * time.Second,
+				reapInterval:         time.Duration(env.GetEnvInt("MESSAGE_REAP_INTERVAL_SECONDS", 60)) * time.Second,
+				undeliveredRetention: time.Duration(env.GetEnvInt("MESSAGE_UNDELIVERED_RETENTION_DAYS", 30)) * time.Hour * 24,
+			},
 			cloud: cloudCfg{
 				s3: s3Cfg{
 					cfg: cloudStorage.NewAWSConfig(
@@ -121,13 +137,18 @@ func main() {
 	logger := zap.Must(zap.NewProduction()).Sugar()
 	defer logger.Sync()
 
-	var cacheStore cache.Storage
-	if conf.cacheCfg.redis.enabled {
-		rdb := cache.NewRedisClient(
+	var rdb *redis.Client
+	if conf.cacheCfg.redis.enabled || conf.hub.redisBackplane {
+		rdb = cache.NewRedisClient(
 			conf.cacheCfg.redis.addr,
 			conf.cacheCfg.redis.pw,
 			conf.cacheCfg.redis.db,
 		)
+		defer rdb.Close()
+	}
+
+	var cacheStore cache.Storage
+	if conf.cacheCfg.redis.enabled {
 		cacheStore = cache.NewRedisStorage(rdb, conf.cacheCfg.expiry)
 		msg, err := rdb.Ping(context.Background()).Result()
 		if err == nil {
@@ -136,6 +157,22 @@ func main() {
 		}
 	}
 
+	var backplane ws.Backplane = ws.NewLocalBackplane()
+	if conf.hub.redisBackplane {
+		backplane = ws.NewRedisBackplane(rdb, conf.hub.nodeID)
+		logger.Infow("hub:redis backplane enabled", "nodeID", conf.hub.nodeID)
+	}
+
+	var presence ws.PresenceStore = ws.NewMemoryPresenceStore()
+	var events ws.EventLog = ws.NewMemoryEventLog()
+	var tickets ws.TicketStore = ws.NewMemoryTicketStore()
+	if rdb != nil {
+		presence = ws.NewRedisPresenceStore(rdb)
+		events = ws.NewRedisEventLog(rdb)
+		tickets = ws.NewRedisTicketStore(rdb)
+	}
+	ws.AllowOrigins(conf.hub.allowedOrigins)
+
 	cloudStorageClient := cloudStorage.NewS3CloudStorage(
 		conf.cloud.s3.cfg,
 	)
@@ -145,6 +182,11 @@ func main() {
 		logger:        logger,
 		authenticator: jwtAuthenticator,
 		cloud:         cloudStorageClient,
+		backplane:     backplane,
+		presence:      presence,
+		events:        events,
+		tickets:       tickets,
+		typing:        newTypingIndicators(typingIndicatorTimeout),
 	}
 
 	if conf.cacheCfg.redis.enabled {
@@ -152,5 +194,7 @@ func main() {
 	}
 	mux := app.mount()
 
-	log.Fatal(app.run(mux))
+	if err := app.run(mux); err != nil {
+		logger.Errorw("server stopped with error", "error", err)
+	}
 }
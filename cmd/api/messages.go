@@ -2,8 +2,10 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/9thDuck/chat_go.git/cmd/api/ws"
 	"github.com/9thDuck/chat_go.git/internal/store"
@@ -12,16 +14,65 @@ import (
 type createMessagePayload struct {
 	Content     string   `json:"content" validate:"required,min=1,max=1000"`
 	Attachments []string `json:"attachments" validate:"omitempty,max=10,dive,max=255"`
+	// ReplyToID must be a message of the same conversation.
+	ReplyToID *int64 `json:"replyToId" validate:"omitempty,gt=0"`
+	// SendAt schedules the message, it is delivered then rather than now.
+	SendAt *time.Time `json:"sendAt"`
+	// ClientMessageID makes retries safe, a message is created once per id
+	// and sender. The Idempotency-Key header can carry it instead.
+	ClientMessageID *string `json:"clientMessageId" validate:"omitempty,min=1,max=64"`
 }
 
+// idempotencyKeyHeader carries the client message id of HTTP requests.
+const idempotencyKeyHeader = "Idempotency-Key"
+
+var errInvalidIdempotencyKey = errors.New("Idempotency-Key must be at most 64 characters and match clientMessageId when both are sent")
+
+// maxScheduleAhead is how far in the future a message can be scheduled.
+const maxScheduleAhead = 365 * 24 * time.Hour
+
+var errInvalidSendAt = errors.New("sendAt must be in the future and at most a year away")
+
+var errForeignAttachment = errors.New("attachments must be uploaded by the sender under their own key prefix")
+
+// ackMessagesPayload acknowledges messages on behalf of a device. Socket
+// clients are identified by their connection, HTTP clients may name the
+// device with deviceId, the deviceId they connect their socket with.
+type ackMessagesPayload struct {
+	MessageIDs []int64 `json:"messageIds" validate:"required,min=1,max=100,dive,gt=0"`
+	DeviceID   string  `json:"deviceId"`
+}
+
+// markReadPayload marks either the listed messages or every message up to
+// upToId sent by senderId as read.
+type markReadPayload struct {
+	SenderID   int64   `json:"senderId" validate:"required,gt=0"`
+	MessageIDs []int64 `json:"messageIds" validate:"required_without=UpToID,omitempty,max=100,dive,gt=0"`
+	UpToID     int64   `json:"upToId" validate:"required_without=MessageIDs,omitempty,gt=0"`
+}
+
+const markReadPayloadValidationErrMsg = "senderId is required along with either messageIds, an array of up to 100 message ids, or upToId"
+
+// pendingRedeliveryBatchSize is how many undelivered messages are read from the
+// store at a time when a client reconnects.
+const pendingRedeliveryBatchSize = 50
+
 const receiverIDCtxKey ctxKey = "receiverID"
+const messageIDCtxKey ctxKey = "messageID"
 const messageCreationPayloadCtxKey ctxKey = "messageCreationPayload"
 
+// maxTombstonesPerSync bounds the tombstones returned by one sync request.
+const maxTombstonesPerSync = 100
+
+type editMessagePayload struct {
+	Content string `json:"content" validate:"required,min=1,max=1000"`
+}
+
 func (app *application) getMessagesHandler(w http.ResponseWriter, r *http.Request) {
 	user := getUserFromCtx(r)
 	pagination := getPaginationOptionsFromCtx(r)
 
-	messages, total, err := app.store.Messages.Get(r.Context(), user.ID, pagination)
+	messages, page, err := app.store.Messages.Get(r.Context(), user.ID, pagination)
 	if err != nil {
 		app.internalError(w, r, err)
 		return
@@ -29,12 +80,13 @@ func (app *application) getMessagesHandler(w http.ResponseWriter, r *http.Reques
 
 	if messages != nil {
 		app.generateSignedURLsForAttachments(r.Context(), messages)
+		if err := app.attachReactionCounts(r.Context(), messages); err != nil {
+			app.internalError(w, r, err)
+			return
+		}
 	}
 
-	app.jsonResponse(w, http.StatusOK, &paginatedEnvelope{
-		Records:      messages,
-		TotalRecords: total,
-	})
+	app.jsonResponse(w, http.StatusOK, newCursorEnvelope(messages, page))
 }
 
 func (app *application) generateSignedURLsForAttachments(ctx context.Context, messages *[]store.Message) {
@@ -67,41 +119,433 @@ func (app *application) createMessageHandler(w http.ResponseWriter, r *http.Requ
 
 	user := getUserFromCtx(r)
 
+	message, err := app.createMessage(r.Context(), user.ID, receiverID, payload)
+
+	switch err {
+	case nil:
+		app.jsonResponse(w, http.StatusCreated, message)
+		app.deliverMessage(r.Context(), message)
+		app.syncSenderDevices(message, nil)
+		return
+	case store.ErrMessageAlreadyExists:
+		app.jsonResponse(w, http.StatusOK, message)
+		return
+	case store.ErrClientMessageIDReused:
+		app.conflictError(w, r, err, "")
+		return
+	case store.ErrInvalidReplyTo, errInvalidSendAt, errForeignAttachment:
+		app.badRequestError(w, r, err, "")
+		return
+	default:
+		app.internalError(w, r, err)
+		return
+	}
+}
+
+func (app *application) createMessage(ctx context.Context, senderID, receiverID int64, payload *createMessagePayload) (*store.Message, error) {
+	message, err := newMessage(senderID, payload)
+	if err != nil {
+		return nil, err
+	}
+	message.ReceiverID = receiverID
+
+	// On ErrMessageAlreadyExists message is the original.
+	if err := app.store.Messages.Create(ctx, message); err != nil {
+		if err == store.ErrMessageAlreadyExists {
+			return message, err
+		}
+		return nil, err
+	}
+	return message, nil
+}
+
+// readIdempotencyKey sets the client message id of payload from the
+// Idempotency-Key header of r, if any.
+func readIdempotencyKey(r *http.Request, payload *createMessagePayload) error {
+	key := r.Header.Get(idempotencyKeyHeader)
+	if key == "" {
+		return nil
+	}
+	if len(key) > 64 || (payload.ClientMessageID != nil && *payload.ClientMessageID != key) {
+		return errInvalidIdempotencyKey
+	}
+	payload.ClientMessageID = &key
+	return nil
+}
+
+func newMessage(senderID int64, payload *createMessagePayload) (*store.Message, error) {
 	message := store.Message{
-		SenderID:    user.ID,
-		ReceiverID:  receiverID,
-		Content:     payload.Content,
-		Attachments: &[]string{},
-		IsDelivered: false,
-		IsRead:      false,
-		Version:     1,
-		Edited:      false,
+		SenderID:        senderID,
+		Content:         payload.Content,
+		Attachments:     &[]string{},
+		IsDelivered:     false,
+		IsRead:          false,
+		Version:         1,
+		Edited:          false,
+		ReplyToID:       payload.ReplyToID,
+		ClientMessageID: payload.ClientMessageID,
 	}
 
 	if payload.Attachments != nil {
+		for _, path := range payload.Attachments {
+			if !store.OwnsAttachment(senderID, path) {
+				return nil, errForeignAttachment
+			}
+		}
 		message.Attachments = &payload.Attachments
 	}
 
-	err := app.store.Messages.Create(r.Context(), &message)
+	if payload.SendAt != nil {
+		sendAt, err := parseSendAt(*payload.SendAt)
+		if err != nil {
+			return nil, err
+		}
+		message.SendAt = &sendAt
+	}
+	return &message, nil
+}
+
+// parseSendAt checks that sendAt is a time a message can be scheduled for and
+// formats it for the store.
+func parseSendAt(sendAt time.Time) (string, error) {
+	now := time.Now()
+	if !sendAt.After(now) || sendAt.After(now.Add(maxScheduleAhead)) {
+		return "", errInvalidSendAt
+	}
+	return sendAt.UTC().Format(time.RFC3339), nil
+}
 
-	switch err {
-	case nil:
-		app.jsonResponse(w, http.StatusCreated, &message)
-		jsonMessage, err := json.Marshal(ws.MessageEvent{
-			Message: message,
-			Type:    ws.EVENT_MESSAGE,
+// deliverMessage pushes message to the sockets of its recipients. The stored
+// copy stays pending until they acknowledge it, see acknowledgeMessages.
+func (app *application) deliverMessage(ctx context.Context, message *store.Message) {
+	// Scheduled messages are delivered by the scheduled message dispatcher.
+	if message.SendAt != nil {
+		return
+	}
+	app.pushMessageEvent(ctx, message, ws.EVENT_MESSAGE)
+}
+
+// syncSenderDevices sends a message just created to every device of its
+// sender, which match it to their local copy by its client message id. origin
+// is the connection the message was sent through and already got it, nil when
+// sent over HTTP.
+func (app *application) syncSenderDevices(message *store.Message, origin *ws.Client) {
+	if message.ClientMessageID == nil {
+		return
+	}
+	app.socketHub.WriteToClientExcept(message.SenderID, &ws.Event{Type: ws.EVENT_MESSAGE_SENT, Data: message}, origin)
+}
+
+// pushMessageEvent sends message as an event of eventType to its receiver, or
+// to every member of its room but the sender.
+func (app *application) pushMessageEvent(ctx context.Context, message *store.Message, eventType string) {
+	recipientIDs, err := app.messageRecipientIDs(ctx, message.SenderID, message.ReceiverID, message.RoomID)
+	if err != nil {
+		app.logger.Errorw("Failed to load message recipients", "messageID", message.ID, "error", err)
+		return
+	}
+
+	event := &ws.Event{Type: eventType, Data: message}
+	for _, recipientID := range recipientIDs {
+		app.socketHub.WriteToClient(recipientID, event)
+	}
+}
+
+// messageRecipientIDs returns who a message sent by senderID goes to, its
+// receiver or, when roomID is set, the other members of that room.
+func (app *application) messageRecipientIDs(ctx context.Context, senderID, receiverID int64, roomID *int64) ([]int64, error) {
+	if roomID == nil {
+		return []int64{receiverID}, nil
+	}
+
+	memberIDs, err := app.store.Rooms.GetMemberIDs(ctx, *roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	recipientIDs := make([]int64, 0, len(memberIDs))
+	for _, memberID := range memberIDs {
+		if memberID != senderID {
+			recipientIDs = append(recipientIDs, memberID)
+		}
+	}
+	return recipientIDs, nil
+}
+
+func (app *application) ackMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	var payload ackMessagesPayload
+	if err := readJson(w, r, &payload); err != nil {
+		app.badRequestError(w, r, err, "")
+		return
+	}
+	if err := Validate.Struct(&payload); err != nil {
+		app.badRequestError(w, r, err, "messageIds must be an array of 1 to 100 message ids")
+		return
+	}
+
+	if payload.DeviceID != "" && !ws.IsValidDeviceID(payload.DeviceID) {
+		app.badRequestError(w, r, nil, "deviceId must be 1 to 64 letters, digits, '-' or '_'")
+		return
+	}
+
+	user := getUserFromCtx(r)
+	deliveredIDs, err := app.acknowledgeMessages(r.Context(), user.ID, payload.DeviceID, payload.MessageIDs)
+	if err != nil {
+		app.internalError(w, r, err)
+		return
+	}
+
+	app.jsonResponse(w, http.StatusOK, &ws.AcknowledgedEventData{MessageIDs: deliveredIDs})
+}
+
+// acknowledgeMessages records that a device of the receiver got messages and
+// marks them as delivered once every device of the receiver did, see
+// MessagesStore.MarkDelivered. Room messages every member got are purged
+// along with their stored attachments. Ids that are unknown, already
+// acknowledged or addressed to someone else are left out of the returned ids.
+func (app *application) acknowledgeMessages(ctx context.Context, receiverID int64, deviceID string, messageIDs []int64) ([]int64, error) {
+	ackedIDs, paths, err := app.store.Messages.MarkDelivered(ctx, receiverID, deviceID, messageIDs)
+	if err != nil {
+		return nil, err
+	}
+	app.deleteDeliveredAttachments(ctx, paths)
+	return ackedIDs, nil
+}
+
+// deleteDeliveredAttachments removes the stored attachments of room messages
+// purged once every member got them, failures are logged and leave orphaned
+// objects behind.
+func (app *application) deleteDeliveredAttachments(ctx context.Context, paths []string) {
+	if len(paths) == 0 {
+		return
+	}
+	if err := app.cloud.Objects.Delete(ctx, app.config.cloud.s3.bucketName, paths); err != nil {
+		app.logger.Errorw("Failed to delete attachments of delivered messages", "count", len(paths), "error", err)
+	}
+}
+
+func (app *application) markMessagesReadHandler(w http.ResponseWriter, r *http.Request) {
+	var payload markReadPayload
+	if err := readJson(w, r, &payload); err != nil {
+		app.badRequestError(w, r, err, "")
+		return
+	}
+	if err := Validate.Struct(&payload); err != nil {
+		app.badRequestError(w, r, err, markReadPayloadValidationErrMsg)
+		return
+	}
+
+	user := getUserFromCtx(r)
+	readIDs, err := app.markMessagesRead(r.Context(), user, &payload)
+	if err != nil {
+		app.internalError(w, r, err)
+		return
+	}
+
+	app.jsonResponse(w, http.StatusOK, &ws.ReadReceiptEventData{ReaderID: user.ID, MessageIDs: readIDs})
+}
+
+// markMessagesRead persists the read state and, unless the reader turned read
+// receipts off, tells the sender which of their messages were read.
+func (app *application) markMessagesRead(ctx context.Context, reader *store.User, payload *markReadPayload) ([]int64, error) {
+	readIDs, err := app.store.Messages.MarkRead(ctx, reader.ID, payload.SenderID, payload.MessageIDs, payload.UpToID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(readIDs) > 0 && reader.ReadReceiptsEnabled {
+		app.socketHub.WriteToClient(payload.SenderID, &ws.Event{
+			Type: ws.EVENT_READ_RECEIPT,
+			Data: &ws.ReadReceiptEventData{ReaderID: reader.ID, MessageIDs: readIDs},
 		})
+	}
+
+	return readIDs, nil
+}
+
+// redeliverPendingMessages pushes every message its device has yet to ack to a
+// freshly connected client. A client with a device id of its own registers
+// the device first, so messages wait for its ack before being delivered.
+func (app *application) redeliverPendingMessages(ctx context.Context, c *ws.Client) {
+	deviceID := c.StableDeviceID()
+	if deviceID != "" {
+		if err := app.store.Messages.RegisterDevice(ctx, c.UserID(), deviceID); err != nil {
+			app.logger.Errorw("Failed to register device", "userID", c.UserID(), "deviceID", deviceID, "error", err)
+		}
+	}
+
+	afterID := int64(0)
+	for {
+		messages, err := app.store.Messages.GetPending(ctx, c.UserID(), deviceID, afterID, pendingRedeliveryBatchSize)
 		if err != nil {
-			app.logger.Errorw("Failed to marshal message", "error", err)
+			app.logger.Errorw("Failed to load pending messages", "userID", c.UserID(), "error", err)
+			return
+		}
+
+		for i := range *messages {
+			c.SendEvent(&ws.Event{
+				Type: ws.EVENT_MESSAGE,
+				Data: &(*messages)[i],
+			})
+		}
+
+		if len(*messages) < pendingRedeliveryBatchSize {
+			return
 		}
-		if done := app.socketHub.WriteToClient(receiverID, jsonMessage); done {
-			app.store.Messages.Delete(r.Context(), message.ID)
+		afterID = (*messages)[len(*messages)-1].ID
+	}
+}
+
+// editMessageHandler lets the sender replace the content of a message, the
+// receiver is sent the edited message.
+func (app *application) editMessageHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+	messageID := getMessageIDFromCtx(r)
+
+	var payload editMessagePayload
+	if err := readJson(w, r, &payload); err != nil {
+		app.badRequestError(w, r, err, "")
+		return
+	}
+	if err := Validate.Struct(&payload); err != nil {
+		app.badRequestError(w, r, err, "content must be between 1 and 1000 characters")
+		return
+	}
+
+	message, err := app.store.Messages.Edit(r.Context(), messageID, user.ID, payload.Content)
+	switch err {
+	case nil:
+	case store.ErrNotFound:
+		app.notFoundError(w, r, err, "message not found")
+		return
+	default:
+		app.internalError(w, r, err)
+		return
+	}
+
+	app.jsonResponse(w, http.StatusOK, message)
+	app.pushMessageEvent(r.Context(), message, ws.EVENT_MESSAGE_EDITED)
+}
+
+// getMessageVersionsHandler returns the previous contents of a message to
+// either of its participants.
+func (app *application) getMessageVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+	messageID := getMessageIDFromCtx(r)
+
+	message, err := app.store.Messages.GetByID(r.Context(), messageID)
+	if err != nil {
+		if err == store.ErrNotFound {
+			app.notFoundError(w, r, err, "message not found")
+			return
 		}
+		app.internalError(w, r, err)
+		return
+	}
+	isParticipant, err := app.isMessageParticipant(r.Context(), message, user.ID)
+	if err != nil {
+		app.internalError(w, r, err)
+		return
+	}
+	if !isParticipant {
+		app.notFoundError(w, r, store.ErrNotFound, "message not found")
+		return
+	}
+
+	versions, err := app.store.Messages.GetVersions(r.Context(), messageID)
+	if err != nil {
+		app.internalError(w, r, err)
+		return
+	}
+
+	app.jsonResponse(w, http.StatusOK, versions)
+}
+
+// isMessageParticipant reports whether userID sent or received message, in
+// person or through a room they are a member of.
+func (app *application) isMessageParticipant(ctx context.Context, message *store.Message, userID int64) (bool, error) {
+	if message.SenderID == userID || message.ReceiverID == userID {
+		return true, nil
+	}
+	if message.RoomID == nil {
+		return false, nil
+	}
+	return app.store.Rooms.IsMember(ctx, *message.RoomID, userID)
+}
+
+// unsendMessageHandler lets the sender delete a message for everyone within
+// the configured unsend window. Participants connected now are sent the
+// tombstone, devices offline pick it up through getMessageTombstonesHandler.
+func (app *application) unsendMessageHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+	messageID := getMessageIDFromCtx(r)
+
+	tombstone, paths, err := app.store.Messages.Unsend(r.Context(), messageID, user.ID, app.config.messages.unsendWindow)
+	switch err {
+	case nil:
+	case store.ErrNotFound:
+		app.notFoundError(w, r, err, "message not found")
+		return
+	case store.ErrUnsendWindowExpired:
+		app.badRequestError(w, r, err, "")
 		return
 	default:
 		app.internalError(w, r, err)
 		return
 	}
+
+	app.deleteAttachmentObjects(r.Context(), messageID, paths)
+	w.WriteHeader(http.StatusNoContent)
+	app.pushTombstone(r.Context(), tombstone)
+}
+
+// deleteAttachmentObjects removes the stored attachments of a deleted message,
+// failures are logged and leave orphaned objects behind.
+func (app *application) deleteAttachmentObjects(ctx context.Context, messageID int64, paths []string) {
+	if len(paths) == 0 {
+		return
+	}
+	if err := app.cloud.Objects.Delete(ctx, app.config.cloud.s3.bucketName, paths); err != nil {
+		app.logger.Errorw("Failed to delete attachments of deleted message", "messageID", messageID, "error", err)
+	}
+}
+
+// pushTombstone tells everyone a deleted message concerned, its sender
+// included, that it is gone.
+func (app *application) pushTombstone(ctx context.Context, tombstone *store.MessageTombstone) {
+	recipientIDs, err := app.messageRecipientIDs(ctx, tombstone.SenderID, tombstone.ReceiverID, tombstone.RoomID)
+	if err != nil {
+		app.logger.Errorw("Failed to load message recipients", "messageID", tombstone.MessageID, "error", err)
+		return
+	}
+	event := &ws.Event{Type: ws.EVENT_MESSAGE_UNSENT, Data: tombstone}
+	for _, recipientID := range append(recipientIDs, tombstone.SenderID) {
+		app.socketHub.WriteToClient(recipientID, event)
+	}
+}
+
+// getMessageTombstonesHandler returns the tombstones of unsent messages the
+// user took part in, recorded after the tombstone id given as after.
+func (app *application) getMessageTombstonesHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+
+	var afterID int64
+	if after := r.URL.Query().Get("after"); after != "" {
+		var err error
+		afterID, err = strconv.ParseInt(after, 10, 64)
+		if err != nil || afterID < 0 {
+			app.badRequestError(w, r, err, "after must be a tombstone id")
+			return
+		}
+	}
+
+	tombstones, err := app.store.Messages.GetTombstones(r.Context(), user.ID, afterID, maxTombstonesPerSync)
+	if err != nil {
+		app.internalError(w, r, err)
+		return
+	}
+
+	app.jsonResponse(w, http.StatusOK, tombstones)
 }
 
 func getReceiverIDFromCtx(r *http.Request) int64 {
@@ -111,3 +555,7 @@ func getReceiverIDFromCtx(r *http.Request) int64 {
 func getMessagePayloadFromCtx(r *http.Request) *createMessagePayload {
 	return r.Context().Value(messageCreationPayloadCtxKey).(*createMessagePayload)
 }
+
+func getMessageIDFromCtx(r *http.Request) int64 {
+	return r.Context().Value(messageIDCtxKey).(int64)
+}
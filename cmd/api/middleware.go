@@ -114,41 +114,6 @@ func (app *application) userDetailsUpdateGuardMiddleware(next http.HandlerFunc)
 	})
 }
 
-// func (app *application) deleteUserAuthorityCheckMiddleware(requiredRoleName string, next http.HandlerFunc) http.HandlerFunc {
-// 	return http.HandlerFunc(
-// 		func(w http.ResponseWriter, r *http.Request) {
-// 			user := getUserFromCtx(r)
-
-// 			idParam := chi.URLParam(r, string(userIDCtxKey))
-// 			if idParam == "" {
-// 				app.BadRequestError(w, r, errors.New("id of user to be deleted is not specified"))
-// 				return
-// 			}
-
-// 			userIDFromParam, err := strconv.ParseInt(idParam, 10, 64)
-// 			if err != nil {
-// 				app.BadRequestError(w, r, err)
-// 				return
-// 			}
-// 			if userIDFromParam == user.ID {
-// 				ctx := context.WithValue(r.Context(), deleteTokenCookiesCtxKey, true)
-// 				next.ServeHTTP(w, r.WithContext(ctx))
-// 				return
-// 			}
-// 			allowed, err := app.checkRolePrecedence(r.Context(), user, requiredRoleName)
-// 			if err != nil {
-// 				app.internalError(w, r, err)
-// 				return
-// 			}
-// 			if !allowed {
-// 				app.forbiddenRequestError(w, r, fmt.Errorf("forbidden action by userID: %d", user.ID))
-// 				return
-// 			}
-
-// 			next.ServeHTTP(w, r)
-// 		})
-// }
-
 func (app *application) getUser(ctx context.Context, userID int64) (*store.User, error) {
 	user := &store.User{ID: userID}
 	user.Role = &domain.Role{}
@@ -222,32 +187,40 @@ func (app *application) getUserWithEncryptionKey(ctx context.Context, userID int
 	return store.NewUserWithEncryptionKey(user, encryptionKey), nil
 }
 
-// func (app *application) roleChangingAuthorityMiddleware(requiredRoleName string, next http.HandlerFunc) http.HandlerFunc {
-// 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-// 		user := getUserFromCtx(r)
-
-// 		allowed, err := app.checkRolePrecedence(r.Context(), user, requiredRoleName)
-// 		if err != nil {
-// 			app.internalError(w, r, err)
-// 			return
-// 		}
-// 		if !allowed {
-// 			app.forbiddenRequestError(w, r, fmt.Errorf("forbidden action by userID: %d", user.ID))
-// 			return
-// 		}
-
-// 		next.ServeHTTP(w, r)
-// 	})
-// }
-
-// func (app *application) checkRolePrecedence(ctx context.Context, user *store.User, requiredRoleName string) (bool, error) {
-// 	requiredRole, err := app.store.Roles.GetByName(ctx, requiredRoleName)
-// 	if err != nil {
-// 		return false, err
-// 	}
-
-// 	return requiredRole.Level <= user.Role.Level, nil
-// }
+// requireRole lets through users whose role is at least as high as the role
+// named requiredRoleName, levels are defined in the roles table.
+func (app *application) requireRole(requiredRoleName string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user := getUserFromCtx(r)
+
+			allowed, err := app.checkRolePrecedence(r.Context(), user, requiredRoleName)
+			if err != nil {
+				app.internalError(w, r, err)
+				return
+			}
+			if !allowed {
+				app.forbiddenRequestError(w, r, fmt.Errorf("forbidden action by userID: %d", user.ID))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (app *application) checkRolePrecedence(ctx context.Context, user *store.User, requiredRoleName string) (bool, error) {
+	if user == nil || user.Role == nil {
+		return false, nil
+	}
+
+	requiredRole, err := app.store.Roles.GetByName(ctx, requiredRoleName)
+	if err != nil {
+		return false, err
+	}
+
+	return requiredRole.Level <= user.Role.Level, nil
+}
 
 func getUserIDFromToken(token *jwt.Token) (int64, error) {
 	claims, _ := token.Claims.(jwt.MapClaims)
@@ -280,7 +253,6 @@ func (app *application) paginationMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		limit := 10
 		page := 1
-		sort := "created_at"
 		sortDirection := "DESC"
 
 		query := r.URL.Query()
@@ -308,13 +280,10 @@ func (app *application) paginationMiddleware(next http.Handler) http.Handler {
 			page = val
 		}
 
-		sortQParam := query.Get("sort")
-		if sortQParam != "" {
-			if sortQParam != "first_name" && sortQParam != "last_name" && sortQParam != "username" && sortQParam != "created_at" {
-				app.badRequestError(w, r, nil, "sort query param must be one of the following: first_name, last_name, username, created_at")
-				return
-			}
-			sort = sortQParam
+		// Every listing has a fixed order, the one its cursors follow. sort is
+		// ignored, the header tells clients still sending it to stop.
+		if query.Has("sort") {
+			w.Header().Set("Deprecation", "true")
 		}
 
 		sortDirectionQParam := query.Get("sort_direction")
@@ -329,10 +298,43 @@ func (app *application) paginationMiddleware(next http.Handler) http.Handler {
 		pagination := &store.Pagination{
 			Limit:         limit,
 			Page:          page,
-			Sort:          sort,
 			SortDirection: sortDirection,
 		}
 
+		// after and before are the opaque cursors of keyset listings.
+		afterQParam := query.Get("after")
+		beforeQParam := query.Get("before")
+		if afterQParam != "" && beforeQParam != "" {
+			app.badRequestError(w, r, nil, "only one of the after and before query params can be set")
+			return
+		}
+		if afterQParam != "" {
+			cursor, err := store.DecodeCursor(afterQParam)
+			if err != nil {
+				app.badRequestError(w, r, err, "after query param must be a cursor returned by a previous page")
+				return
+			}
+			pagination.After = cursor
+		}
+		if beforeQParam != "" {
+			cursor, err := store.DecodeCursor(beforeQParam)
+			if err != nil {
+				app.badRequestError(w, r, err, "before query param must be a cursor returned by a previous page")
+				return
+			}
+			pagination.Before = cursor
+		}
+
+		includeTotalQParam := query.Get("include_total")
+		if includeTotalQParam != "" {
+			val, err := strconv.ParseBool(includeTotalQParam)
+			if err != nil {
+				app.badRequestError(w, r, nil, "include_total query param must be true or false")
+				return
+			}
+			pagination.WithTotal = val
+		}
+
 		ctx := context.WithValue(r.Context(), paginationCtxKey, pagination)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
@@ -367,7 +369,7 @@ func (app *application) blockSelfContactRequestMiddleware(next http.Handler) htt
 
 func (app *application) getReceiverIDParamMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		receiverID := chi.URLParam(r, "receiverID")
+		receiverID := chi.URLParam(r, "id")
 		if receiverID == "" {
 			app.badRequestError(w, r, nil, "receiver_id is required to send message")
 			return
@@ -382,6 +384,18 @@ func (app *application) getReceiverIDParamMiddleware(next http.Handler) http.Han
 	})
 }
 
+func (app *application) getMessageIDParamMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		messageID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil || messageID < 1 {
+			app.badRequestError(w, r, err, "invalid message id")
+			return
+		}
+		ctx := context.WithValue(r.Context(), messageIDCtxKey, messageID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
 func (app *application) preMessageCreationMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
@@ -400,6 +414,10 @@ func (app *application) preMessageCreationMiddleware(next http.Handler) http.Han
 			app.badRequestError(w, r, err, payloadValidationErrMsg)
 			return
 		}
+		if err := readIdempotencyKey(r, &payload); err != nil {
+			app.badRequestError(w, r, err, "")
+			return
+		}
 		user := getUserFromCtx(r)
 		receiverID := getReceiverIDFromCtx(r)
 		areContacts, err := app.checkContactRelationship(r.Context(), user.ID, receiverID)
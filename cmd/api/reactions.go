@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/9thDuck/chat_go.git/cmd/api/ws"
+	"github.com/9thDuck/chat_go.git/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+type reactionPayload struct {
+	Emoji string `json:"emoji" validate:"required,max=16,emoji"`
+}
+
+const reactionPayloadValidationErrMsg = "emoji is required and must be a single emoji"
+
+func (app *application) addReactionHandler(w http.ResponseWriter, r *http.Request) {
+	var payload reactionPayload
+	if err := readJson(w, r, &payload); err != nil {
+		app.badRequestError(w, r, err, reactionPayloadValidationErrMsg)
+		return
+	}
+	message, ok := app.readReactionRequest(w, r, &payload)
+	if !ok {
+		return
+	}
+	user := getUserFromCtx(r)
+
+	reaction, err := app.store.Reactions.Add(r.Context(), message.ID, user.ID, payload.Emoji)
+	switch err {
+	case nil:
+	case store.ErrReactionAlreadyExists:
+		app.badRequestError(w, r, err, "")
+		return
+	case store.ErrNotFound:
+		app.notFoundError(w, r, err, "message not found")
+		return
+	default:
+		app.internalError(w, r, err)
+		return
+	}
+
+	app.jsonResponse(w, http.StatusCreated, reaction)
+	app.pushReactionEvent(r.Context(), message, &ws.Event{
+		Type: ws.EVENT_REACTION_ADDED,
+		Data: ws.ReactionEventData{MessageID: message.ID, UserID: user.ID, Emoji: payload.Emoji},
+	})
+}
+
+// removeReactionHandler removes the reaction named by the emoji path param,
+// percent-encoded.
+func (app *application) removeReactionHandler(w http.ResponseWriter, r *http.Request) {
+	emoji, err := url.PathUnescape(chi.URLParam(r, "emoji"))
+	if err != nil {
+		app.badRequestError(w, r, err, reactionPayloadValidationErrMsg)
+		return
+	}
+	payload := reactionPayload{Emoji: emoji}
+	message, ok := app.readReactionRequest(w, r, &payload)
+	if !ok {
+		return
+	}
+	user := getUserFromCtx(r)
+
+	switch err := app.store.Reactions.Remove(r.Context(), message.ID, user.ID, payload.Emoji); err {
+	case nil:
+	case store.ErrReactionNotFound:
+		app.notFoundError(w, r, err, "")
+		return
+	default:
+		app.internalError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	app.pushReactionEvent(r.Context(), message, &ws.Event{
+		Type: ws.EVENT_REACTION_REMOVED,
+		Data: ws.ReactionEventData{MessageID: message.ID, UserID: user.ID, Emoji: payload.Emoji},
+	})
+}
+
+// readReactionRequest validates payload and loads the message of the request,
+// which the user must have taken part in. It writes the error response and
+// returns false otherwise.
+func (app *application) readReactionRequest(w http.ResponseWriter, r *http.Request, payload *reactionPayload) (*store.Message, bool) {
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestError(w, r, err, reactionPayloadValidationErrMsg)
+		return nil, false
+	}
+
+	message, err := app.store.Messages.GetByID(r.Context(), getMessageIDFromCtx(r))
+	switch err {
+	case nil:
+	case store.ErrNotFound:
+		app.notFoundError(w, r, err, "message not found")
+		return nil, false
+	default:
+		app.internalError(w, r, err)
+		return nil, false
+	}
+
+	isParticipant, err := app.isMessageParticipant(r.Context(), message, getUserFromCtx(r).ID)
+	if err != nil {
+		app.internalError(w, r, err)
+		return nil, false
+	}
+	if !isParticipant {
+		app.notFoundError(w, r, store.ErrNotFound, "message not found")
+		return nil, false
+	}
+
+	return message, true
+}
+
+// pushReactionEvent sends event to every participant of message. The user who
+// reacted is included, their other devices learn about the change that way.
+func (app *application) pushReactionEvent(ctx context.Context, message *store.Message, event *ws.Event) {
+	recipientIDs, err := app.messageRecipientIDs(ctx, message.SenderID, message.ReceiverID, message.RoomID)
+	if err != nil {
+		app.logger.Errorw("Failed to load message recipients", "messageID", message.ID, "error", err)
+		return
+	}
+
+	for _, recipientID := range append(recipientIDs, message.SenderID) {
+		app.socketHub.WriteToClient(recipientID, event)
+	}
+}
+
+// attachReactionCounts fills in the reaction counts of messages.
+func (app *application) attachReactionCounts(ctx context.Context, messages *[]store.Message) error {
+	if messages == nil || len(*messages) == 0 {
+		return nil
+	}
+
+	messageIDs := make([]int64, len(*messages))
+	for i, message := range *messages {
+		messageIDs[i] = message.ID
+	}
+
+	counts, err := app.store.Reactions.CountByMessages(ctx, messageIDs)
+	if err != nil {
+		return err
+	}
+
+	for i := range *messages {
+		message := &(*messages)[i]
+		message.Reactions = counts[message.ID]
+	}
+	return nil
+}
+
+// isEmoji reports whether s is a single emoji, possibly a sequence joined with
+// zero width joiners, a flag, a keycap or one with skin tone and presentation
+// modifiers.
+func isEmoji(s string) bool {
+	if s == "" || !utf8.ValidString(s) {
+		return false
+	}
+	// A keycap is a digit, '#' or '*' followed by U+20E3.
+	if strings.HasSuffix(s, "\u20e3") {
+		base := strings.TrimSuffix(strings.TrimSuffix(s, "\u20e3"), "\ufe0f")
+		return len(base) == 1 && strings.ContainsAny(base, "0123456789#*")
+	}
+
+	pictographs := 0
+	for _, r := range s {
+		switch {
+		case isPictograph(r):
+			pictographs++
+		case r == 0x200d, // zero width joiner
+			r == 0xfe0e, r == 0xfe0f, // presentation selectors
+			r >= 0x1f3fb && r <= 0x1f3ff, // skin tones
+			r >= 0xe0020 && r <= 0xe007f: // tags, of subdivision flags
+		default:
+			return false
+		}
+	}
+	return pictographs > 0
+}
+
+// isPictograph reports whether r is in one of the blocks emoji are drawn from.
+func isPictograph(r rune) bool {
+	switch {
+	case r >= 0x1f000 && r <= 0x1faff, // symbols and pictographs, flags
+		r >= 0x2600 && r <= 0x27bf, // miscellaneous symbols, dingbats
+		r >= 0x2300 && r <= 0x23ff, // miscellaneous technical
+		r >= 0x2190 && r <= 0x21ff, // arrows
+		r >= 0x25a0 && r <= 0x25ff, // geometric shapes
+		r >= 0x2b00 && r <= 0x2bff, // miscellaneous symbols and arrows
+		r >= 0x2934 && r <= 0x2935,
+		r == 0x00a9, r == 0x00ae, r == 0x203c, r == 0x2049, r == 0x2122,
+		r == 0x2139, r == 0x24c2, r == 0x3030, r == 0x303d, r == 0x3297, r == 0x3299:
+		return true
+	}
+	return false
+}
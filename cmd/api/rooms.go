@@ -0,0 +1,569 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/9thDuck/chat_go.git/cmd/api/ws"
+	"github.com/9thDuck/chat_go.git/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	roomCtxKey       ctxKey = "room"
+	roomMemberCtxKey ctxKey = "roomMember"
+)
+
+type createRoomPayload struct {
+	Name      string  `json:"name" validate:"required,min=1,max=100"`
+	MemberIDs []int64 `json:"memberIds" validate:"omitempty,max=100,dive,gt=0"`
+}
+
+type updateRoomPayload struct {
+	Name string `json:"name" validate:"required,min=1,max=100"`
+}
+
+type addRoomMemberPayload struct {
+	UserID int64 `json:"userId" validate:"required,gt=0"`
+}
+
+type updateRoomMemberRolePayload struct {
+	Role string `json:"role" validate:"required,oneof=moderator member"`
+}
+
+type muteRoomMemberPayload struct {
+	Minutes int `json:"minutes" validate:"required,min=1,max=43200"`
+}
+
+func (app *application) getRoomsHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+	pagination := getPaginationOptionsFromCtx(r)
+
+	rooms, page, err := app.store.Rooms.GetByMember(r.Context(), user.ID, pagination)
+	if err != nil {
+		app.internalError(w, r, err)
+		return
+	}
+
+	app.jsonResponse(w, http.StatusOK, newCursorEnvelope(rooms, page))
+}
+
+func (app *application) createRoomHandler(w http.ResponseWriter, r *http.Request) {
+	const payloadValidationErrMsg = "name must be between 1 and 100 characters, memberIds must be an array of up to 100 user ids"
+
+	var payload createRoomPayload
+	if err := readJson(w, r, &payload); err != nil {
+		app.badRequestError(w, r, err, payloadValidationErrMsg)
+		return
+	}
+	if err := Validate.Struct(&payload); err != nil {
+		app.badRequestError(w, r, err, payloadValidationErrMsg)
+		return
+	}
+
+	user := getUserFromCtx(r)
+	for _, memberID := range payload.MemberIDs {
+		if memberID == user.ID {
+			continue
+		}
+		areContacts, err := app.checkContactRelationship(r.Context(), user.ID, memberID)
+		if err != nil {
+			app.internalError(w, r, err)
+			return
+		}
+		if !areContacts {
+			app.badRequestError(w, r, nil, "You can only add users in your contacts list to a room")
+			return
+		}
+	}
+
+	room := store.Room{
+		Name:      payload.Name,
+		OwnerID:   &user.ID,
+		MemberIDs: append([]int64{user.ID}, payload.MemberIDs...),
+	}
+
+	if err := app.store.Rooms.Create(r.Context(), &room); err != nil {
+		app.internalError(w, r, err)
+		return
+	}
+
+	created, err := app.store.Rooms.GetByID(r.Context(), room.ID)
+	if err != nil {
+		app.internalError(w, r, err)
+		return
+	}
+
+	app.jsonResponse(w, http.StatusCreated, created)
+	app.pushRoomEvent(created.MemberIDs, user.ID, &ws.Event{Type: ws.EVENT_ROOM_UPDATED, Data: created})
+}
+
+func (app *application) getRoomHandler(w http.ResponseWriter, r *http.Request) {
+	app.jsonResponse(w, http.StatusOK, getRoomFromCtx(r))
+}
+
+func (app *application) updateRoomHandler(w http.ResponseWriter, r *http.Request) {
+	room := getRoomFromCtx(r)
+	user := getUserFromCtx(r)
+
+	var payload updateRoomPayload
+	if err := readJson(w, r, &payload); err != nil {
+		app.badRequestError(w, r, err, "name must be between 1 and 100 characters")
+		return
+	}
+	if err := Validate.Struct(&payload); err != nil {
+		app.badRequestError(w, r, err, "name must be between 1 and 100 characters")
+		return
+	}
+
+	room.Name = payload.Name
+	switch err := app.store.Rooms.Update(r.Context(), room); err {
+	case nil:
+		app.jsonResponse(w, http.StatusOK, room)
+		app.pushRoomEvent(room.MemberIDs, user.ID, &ws.Event{Type: ws.EVENT_ROOM_UPDATED, Data: room})
+	case store.ErrNotFound:
+		app.notFoundError(w, r, err, "room not found")
+	default:
+		app.internalError(w, r, err)
+	}
+}
+
+func (app *application) deleteRoomHandler(w http.ResponseWriter, r *http.Request) {
+	room := getRoomFromCtx(r)
+	user := getUserFromCtx(r)
+
+	switch err := app.store.Rooms.Delete(r.Context(), room.ID); err {
+	case nil:
+		w.WriteHeader(http.StatusNoContent)
+		app.pushRoomEvent(room.MemberIDs, user.ID, &ws.Event{
+			Type: ws.EVENT_ROOM_REMOVED,
+			Data: ws.RoomRemovedEventData{RoomID: room.ID},
+		})
+	case store.ErrNotFound:
+		app.notFoundError(w, r, err, "room not found")
+	default:
+		app.internalError(w, r, err)
+	}
+}
+
+func (app *application) addRoomMemberHandler(w http.ResponseWriter, r *http.Request) {
+	room := getRoomFromCtx(r)
+	user := getUserFromCtx(r)
+
+	var payload addRoomMemberPayload
+	if err := readJson(w, r, &payload); err != nil {
+		app.badRequestError(w, r, err, "userId is required")
+		return
+	}
+	if err := Validate.Struct(&payload); err != nil {
+		app.badRequestError(w, r, err, "userId is required")
+		return
+	}
+
+	areContacts, err := app.checkContactRelationship(r.Context(), user.ID, payload.UserID)
+	if err != nil {
+		app.internalError(w, r, err)
+		return
+	}
+	if !areContacts {
+		app.badRequestError(w, r, nil, "You can only add users in your contacts list to a room")
+		return
+	}
+
+	switch err := app.store.Rooms.AddMember(r.Context(), room.ID, payload.UserID); err {
+	case nil:
+	case store.ErrRoomMemberAlreadyExists:
+		app.badRequestError(w, r, err, "user is already a member of this room")
+		return
+	case store.ErrNotFound:
+		app.notFoundError(w, r, err, "room not found")
+		return
+	default:
+		app.internalError(w, r, err)
+		return
+	}
+
+	room.MemberIDs = append(room.MemberIDs, payload.UserID)
+	app.jsonResponse(w, http.StatusOK, room)
+	app.pushRoomEvent(room.MemberIDs, user.ID, &ws.Event{Type: ws.EVENT_ROOM_UPDATED, Data: room})
+}
+
+// removeRoomMemberHandler lets a member leave a room, or a moderator remove
+// someone they outrank from it. The owner has to delete the room instead of
+// leaving.
+func (app *application) removeRoomMemberHandler(w http.ResponseWriter, r *http.Request) {
+	room := getRoomFromCtx(r)
+	user := getUserFromCtx(r)
+	memberID := getUserIDParamFromCtx(r)
+
+	if memberID == user.ID {
+		if getRoomMemberFromCtx(r).Role == store.ROOM_ROLE_OWNER {
+			app.badRequestError(w, r, nil, "the owner cannot leave a room, delete it instead")
+			return
+		}
+	} else if _, ok := app.getOutrankedRoomMember(w, r, memberID); !ok {
+		return
+	}
+
+	paths, err := app.store.Rooms.RemoveMember(r.Context(), room.ID, memberID)
+	switch err {
+	case nil:
+	case store.ErrRoomMemberNotFound:
+		app.notFoundError(w, r, err, "")
+		return
+	default:
+		app.internalError(w, r, err)
+		return
+	}
+	app.deleteDeliveredAttachments(r.Context(), paths)
+
+	room.MemberIDs = withoutID(room.MemberIDs, memberID)
+	room.ModeratorIDs = withoutID(room.ModeratorIDs, memberID)
+
+	w.WriteHeader(http.StatusNoContent)
+	app.pushRoomEvent([]int64{memberID}, user.ID, &ws.Event{
+		Type: ws.EVENT_ROOM_REMOVED,
+		Data: ws.RoomRemovedEventData{RoomID: room.ID},
+	})
+	app.pushRoomEvent(room.MemberIDs, user.ID, &ws.Event{Type: ws.EVENT_ROOM_UPDATED, Data: room})
+}
+
+// updateRoomMemberRoleHandler lets the owner make a member a moderator or
+// take the role away again.
+func (app *application) updateRoomMemberRoleHandler(w http.ResponseWriter, r *http.Request) {
+	room := getRoomFromCtx(r)
+	user := getUserFromCtx(r)
+	memberID := getUserIDParamFromCtx(r)
+
+	var payload updateRoomMemberRolePayload
+	if err := readJson(w, r, &payload); err != nil {
+		app.badRequestError(w, r, err, "role must be one of the following: moderator, member")
+		return
+	}
+	if err := Validate.Struct(&payload); err != nil {
+		app.badRequestError(w, r, err, "role must be one of the following: moderator, member")
+		return
+	}
+
+	if _, ok := app.getOutrankedRoomMember(w, r, memberID); !ok {
+		return
+	}
+
+	member, err := app.store.Rooms.SetMemberRole(r.Context(), room.ID, memberID, payload.Role)
+	switch err {
+	case nil:
+	case store.ErrRoomMemberNotFound:
+		app.notFoundError(w, r, err, "")
+		return
+	default:
+		app.internalError(w, r, err)
+		return
+	}
+
+	app.jsonResponse(w, http.StatusOK, member)
+	app.pushRoomEvent(room.MemberIDs, user.ID, &ws.Event{Type: ws.EVENT_ROOM_MEMBER_UPDATED, Data: member})
+}
+
+// muteRoomMemberHandler stops a member from sending messages to the room for
+// the given number of minutes.
+func (app *application) muteRoomMemberHandler(w http.ResponseWriter, r *http.Request) {
+	room := getRoomFromCtx(r)
+	user := getUserFromCtx(r)
+	memberID := getUserIDParamFromCtx(r)
+
+	var payload muteRoomMemberPayload
+	if err := readJson(w, r, &payload); err != nil {
+		app.badRequestError(w, r, err, "minutes must be a number between 1 and 43200")
+		return
+	}
+	if err := Validate.Struct(&payload); err != nil {
+		app.badRequestError(w, r, err, "minutes must be a number between 1 and 43200")
+		return
+	}
+
+	if _, ok := app.getOutrankedRoomMember(w, r, memberID); !ok {
+		return
+	}
+
+	member, err := app.store.Rooms.Mute(r.Context(), room.ID, memberID, time.Duration(payload.Minutes)*time.Minute)
+	switch err {
+	case nil:
+	case store.ErrRoomMemberNotFound:
+		app.notFoundError(w, r, err, "")
+		return
+	default:
+		app.internalError(w, r, err)
+		return
+	}
+
+	app.jsonResponse(w, http.StatusOK, member)
+	app.pushRoomEvent(room.MemberIDs, user.ID, &ws.Event{Type: ws.EVENT_ROOM_MEMBER_UPDATED, Data: member})
+}
+
+func (app *application) unmuteRoomMemberHandler(w http.ResponseWriter, r *http.Request) {
+	room := getRoomFromCtx(r)
+	user := getUserFromCtx(r)
+	memberID := getUserIDParamFromCtx(r)
+
+	if _, ok := app.getOutrankedRoomMember(w, r, memberID); !ok {
+		return
+	}
+
+	member, err := app.store.Rooms.Unmute(r.Context(), room.ID, memberID)
+	switch err {
+	case nil:
+	case store.ErrRoomMemberNotFound:
+		app.notFoundError(w, r, err, "")
+		return
+	default:
+		app.internalError(w, r, err)
+		return
+	}
+
+	app.jsonResponse(w, http.StatusOK, member)
+	app.pushRoomEvent(room.MemberIDs, user.ID, &ws.Event{Type: ws.EVENT_ROOM_MEMBER_UPDATED, Data: member})
+}
+
+// deleteRoomMessageHandler lets moderators delete any message sent to the
+// room, with no time limit unlike unsending.
+func (app *application) deleteRoomMessageHandler(w http.ResponseWriter, r *http.Request) {
+	room := getRoomFromCtx(r)
+	messageID := getMessageIDFromCtx(r)
+
+	tombstone, paths, err := app.store.Messages.DeleteFromRoom(r.Context(), messageID, room.ID)
+	switch err {
+	case nil:
+	case store.ErrNotFound:
+		app.notFoundError(w, r, err, "message not found")
+		return
+	default:
+		app.internalError(w, r, err)
+		return
+	}
+
+	app.deleteAttachmentObjects(r.Context(), messageID, paths)
+	w.WriteHeader(http.StatusNoContent)
+	app.pushTombstone(r.Context(), tombstone)
+}
+
+func (app *application) getRoomMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	room := getRoomFromCtx(r)
+	pagination := getPaginationOptionsFromCtx(r)
+
+	messages, page, err := app.store.Messages.GetByRoom(r.Context(), room.ID, pagination)
+	if err != nil {
+		app.internalError(w, r, err)
+		return
+	}
+
+	app.generateSignedURLsForAttachments(r.Context(), messages)
+	if err := app.attachReactionCounts(r.Context(), messages); err != nil {
+		app.internalError(w, r, err)
+		return
+	}
+
+	app.jsonResponse(w, http.StatusOK, newCursorEnvelope(messages, page))
+}
+
+func (app *application) createRoomMessageHandler(w http.ResponseWriter, r *http.Request) {
+	const payloadValidationErrMsg = "content must be between 1 and 1000 characters, attachments must be an array of strings not more than 10 elements, each string must be less than 255 characters"
+
+	var payload createMessagePayload
+	if err := readJson(w, r, &payload); err != nil {
+		app.badRequestError(w, r, err, payloadValidationErrMsg)
+		return
+	}
+	if err := Validate.Struct(&payload); err != nil {
+		app.badRequestError(w, r, err, payloadValidationErrMsg)
+		return
+	}
+	if err := readIdempotencyKey(r, &payload); err != nil {
+		app.badRequestError(w, r, err, "")
+		return
+	}
+
+	room := getRoomFromCtx(r)
+	user := getUserFromCtx(r)
+
+	if getRoomMemberFromCtx(r).IsMuted {
+		app.forbiddenRequestError(w, r, fmt.Errorf("userID %d is muted in roomID %d", user.ID, room.ID))
+		return
+	}
+
+	message, err := app.createRoomMessage(r.Context(), user.ID, room.ID, &payload)
+	switch err {
+	case nil:
+	case store.ErrMessageAlreadyExists:
+		app.jsonResponse(w, http.StatusOK, message)
+		return
+	case store.ErrClientMessageIDReused:
+		app.conflictError(w, r, err, "")
+		return
+	case store.ErrInvalidReplyTo, errInvalidSendAt, errForeignAttachment:
+		app.badRequestError(w, r, err, "")
+		return
+	default:
+		app.internalError(w, r, err)
+		return
+	}
+
+	app.jsonResponse(w, http.StatusCreated, message)
+	app.deliverMessage(r.Context(), message)
+	app.syncSenderDevices(message, nil)
+}
+
+func (app *application) createRoomMessage(ctx context.Context, senderID, roomID int64, payload *createMessagePayload) (*store.Message, error) {
+	message, err := newMessage(senderID, payload)
+	if err != nil {
+		return nil, err
+	}
+	message.RoomID = &roomID
+
+	// On ErrMessageAlreadyExists message is the original.
+	if err := app.store.Messages.Create(ctx, message); err != nil {
+		if err == store.ErrMessageAlreadyExists {
+			return message, err
+		}
+		return nil, err
+	}
+	return message, nil
+}
+
+// pushRoomEvent sends event to every user in userIDs except the one whose
+// action caused it.
+func (app *application) pushRoomEvent(userIDs []int64, actorID int64, event *ws.Event) {
+	for _, userID := range userIDs {
+		if userID != actorID {
+			app.socketHub.WriteToClient(userID, event)
+		}
+	}
+}
+
+// roomMemberMiddleware loads the room in the roomID param. Rooms the user is
+// not a member of are reported as not found.
+func (app *application) roomMemberMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		roomID, err := strconv.ParseInt(chi.URLParam(r, "roomID"), 10, 64)
+		if err != nil || roomID < 1 {
+			app.badRequestError(w, r, err, "invalid room id")
+			return
+		}
+
+		room, err := app.store.Rooms.GetByID(r.Context(), roomID)
+		switch err {
+		case nil:
+		case store.ErrNotFound:
+			app.notFoundError(w, r, err, "room not found")
+			return
+		default:
+			app.internalError(w, r, err)
+			return
+		}
+
+		user := getUserFromCtx(r)
+		member, err := app.store.Rooms.GetMember(r.Context(), room.ID, user.ID)
+		switch err {
+		case nil:
+		case store.ErrRoomMemberNotFound:
+			app.notFoundError(w, r, store.ErrNotFound, "room not found")
+			return
+		default:
+			app.internalError(w, r, err)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), roomCtxKey, room)
+		ctx = context.WithValue(ctx, roomMemberCtxKey, member)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requireRoomRole lets through members whose role in the room loaded by
+// roomMemberMiddleware is at least required.
+func (app *application) requireRoomRole(required string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			role, err := app.effectiveRoomRole(r)
+			if err != nil {
+				app.internalError(w, r, err)
+				return
+			}
+			if !store.HasRoomRole(role, required) {
+				app.forbiddenRequestError(w, r, fmt.Errorf("forbidden action by userID: %d", getUserFromCtx(r).ID))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// effectiveRoomRole is the role of the user in the room of the request. Site
+// moderators and admins moderate every room they are a member of.
+func (app *application) effectiveRoomRole(r *http.Request) (string, error) {
+	member := getRoomMemberFromCtx(r)
+	if member.Role != store.ROOM_ROLE_MEMBER {
+		return member.Role, nil
+	}
+
+	isModerator, err := app.checkRolePrecedence(r.Context(), getUserFromCtx(r), store.ROLE_MODERATOR)
+	if err != nil {
+		return "", err
+	}
+	if isModerator {
+		return store.ROOM_ROLE_MODERATOR, nil
+	}
+	return member.Role, nil
+}
+
+// getOutrankedRoomMember loads the member targetID of the room of the request
+// and checks the user holds a higher role than them. It writes the error
+// response and returns false otherwise.
+func (app *application) getOutrankedRoomMember(w http.ResponseWriter, r *http.Request, targetID int64) (*store.RoomMember, bool) {
+	room := getRoomFromCtx(r)
+
+	role, err := app.effectiveRoomRole(r)
+	if err != nil {
+		app.internalError(w, r, err)
+		return nil, false
+	}
+
+	target, err := app.store.Rooms.GetMember(r.Context(), room.ID, targetID)
+	switch err {
+	case nil:
+	case store.ErrRoomMemberNotFound:
+		app.notFoundError(w, r, err, "")
+		return nil, false
+	default:
+		app.internalError(w, r, err)
+		return nil, false
+	}
+
+	if target.UserID == getUserFromCtx(r).ID || store.HasRoomRole(target.Role, role) {
+		app.forbiddenRequestError(w, r, fmt.Errorf("userID %d cannot moderate userID %d in roomID %d", getUserFromCtx(r).ID, targetID, room.ID))
+		return nil, false
+	}
+
+	return target, true
+}
+
+func withoutID(ids []int64, id int64) []int64 {
+	remaining := make([]int64, 0, len(ids))
+	for _, other := range ids {
+		if other != id {
+			remaining = append(remaining, other)
+		}
+	}
+	return remaining
+}
+
+func getRoomMemberFromCtx(r *http.Request) *store.RoomMember {
+	return r.Context().Value(roomMemberCtxKey).(*store.RoomMember)
+}
+
+func getRoomFromCtx(r *http.Request) *store.Room {
+	return r.Context().Value(roomCtxKey).(*store.Room)
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"fmt"
 	"net/http"
 
+	"github.com/9thDuck/chat_go.git/internal/store"
 	"github.com/go-chi/chi/v5"
 )
 
@@ -20,6 +22,13 @@ func (app *application) getPresignedS3URLHandler(w http.ResponseWriter, r *http.
 
 	switch operation {
 	case "upload":
+		// Users upload under their own prefix only, it is how messages prove
+		// their attachments belong to the sender.
+		user := getUserFromCtx(r)
+		if !store.OwnsAttachment(user.ID, objectKey) {
+			app.forbiddenRequestError(w, r, fmt.Errorf("userID %d cannot upload to key %q", user.ID, objectKey))
+			return
+		}
 		presignedURL, err = app.cloud.PreSigner.Create(ctx, app.config.cloud.s3.bucketName, objectKey, 60)
 	case "download", "":
 		presignedURL, err = app.cloud.PreSigner.Get(ctx, app.config.cloud.s3.bucketName, objectKey, 60)
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/9thDuck/chat_go.git/cmd/api/ws"
+	"github.com/9thDuck/chat_go.git/internal/store"
+)
+
+// scheduledReleaseBatchSize is how many due messages are released at a time.
+const scheduledReleaseBatchSize = 100
+
+// editScheduledMessagePayload changes the content, the send time or both of a
+// scheduled message.
+type editScheduledMessagePayload struct {
+	Content *string    `json:"content" validate:"required_without=SendAt,omitempty,min=1,max=1000"`
+	SendAt  *time.Time `json:"sendAt" validate:"required_without=Content"`
+}
+
+const editScheduledMessagePayloadValidationErrMsg = "content, between 1 and 1000 characters, or sendAt is required"
+
+func (app *application) getScheduledMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+	pagination := getPaginationOptionsFromCtx(r)
+
+	messages, page, err := app.store.Messages.GetScheduled(r.Context(), user.ID, pagination)
+	if err != nil {
+		app.internalError(w, r, err)
+		return
+	}
+
+	app.generateSignedURLsForAttachments(r.Context(), messages)
+	app.jsonResponse(w, http.StatusOK, newCursorEnvelope(messages, page))
+}
+
+func (app *application) editScheduledMessageHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+	messageID := getMessageIDFromCtx(r)
+
+	var payload editScheduledMessagePayload
+	if err := readJson(w, r, &payload); err != nil {
+		app.badRequestError(w, r, err, editScheduledMessagePayloadValidationErrMsg)
+		return
+	}
+	if err := Validate.Struct(&payload); err != nil {
+		app.badRequestError(w, r, err, editScheduledMessagePayloadValidationErrMsg)
+		return
+	}
+
+	var sendAt *string
+	if payload.SendAt != nil {
+		parsed, err := parseSendAt(*payload.SendAt)
+		if err != nil {
+			app.badRequestError(w, r, err, "")
+			return
+		}
+		sendAt = &parsed
+	}
+
+	message, err := app.store.Messages.EditScheduled(r.Context(), messageID, user.ID, payload.Content, sendAt)
+	switch err {
+	case nil:
+	case store.ErrNotFound:
+		app.notFoundError(w, r, err, "scheduled message not found")
+		return
+	default:
+		app.internalError(w, r, err)
+		return
+	}
+
+	app.jsonResponse(w, http.StatusOK, message)
+}
+
+func (app *application) cancelScheduledMessageHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+	messageID := getMessageIDFromCtx(r)
+
+	paths, err := app.store.Messages.CancelScheduled(r.Context(), messageID, user.ID)
+	switch err {
+	case nil:
+	case store.ErrNotFound:
+		app.notFoundError(w, r, err, "scheduled message not found")
+		return
+	default:
+		app.internalError(w, r, err)
+		return
+	}
+
+	app.deleteAttachmentObjects(r.Context(), messageID, paths)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// runScheduledMessageDispatcher releases the scheduled messages that are due
+// every scheduleInterval until ctx is done. Several nodes can run it at once,
+// each message is released by one of them.
+func (app *application) runScheduledMessageDispatcher(ctx context.Context) {
+	ticker := time.NewTicker(app.config.messages.scheduleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			app.releaseDueMessages(ctx)
+		}
+	}
+}
+
+// releaseDueMessages releases due messages in batches and delivers them the
+// way messages sent right away are. Their senders are told the messages went
+// out, on every device. The attachments of messages dropped instead are
+// removed from storage.
+func (app *application) releaseDueMessages(ctx context.Context) {
+	for {
+		due, err := app.store.Messages.ReleaseDue(ctx, scheduledReleaseBatchSize)
+		if err != nil {
+			if !errors.Is(err, context.Canceled) {
+				app.logger.Errorw("Failed to release scheduled messages", "error", err)
+			}
+			return
+		}
+
+		if due.Dropped > 0 {
+			app.logger.Infow("Dropped scheduled messages their senders may no longer send", "count", due.Dropped)
+		}
+		if len(due.Paths) > 0 {
+			if err := app.cloud.Objects.Delete(ctx, app.config.cloud.s3.bucketName, due.Paths); err != nil {
+				app.logger.Errorw("Failed to delete attachments of dropped scheduled messages", "count", len(due.Paths), "error", err)
+			}
+		}
+
+		for i := range due.Released {
+			message := &due.Released[i]
+			app.deliverMessage(ctx, message)
+			app.socketHub.WriteToClient(message.SenderID, &ws.Event{
+				Type: ws.EVENT_SCHEDULED_MESSAGE_RELEASED,
+				Data: message,
+			})
+		}
+
+		if due.Count() < scheduledReleaseBatchSize {
+			return
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/9thDuck/chat_go.git/internal/store"
+)
+
+// dueMessages hands out one release batch per ReleaseDue call. Any other store
+// method is left to the embedded store, which has no database.
+type dueMessages struct {
+	*store.MessagesStore
+	batches []*store.DueMessages
+	calls   int
+}
+
+func (m *dueMessages) ReleaseDue(ctx context.Context, limit int) (*store.DueMessages, error) {
+	if m.calls == len(m.batches) {
+		return nil, errors.New("released more batches than expected")
+	}
+	batch := m.batches[m.calls]
+	m.calls++
+	if batch == nil {
+		return nil, errors.New("connection reset")
+	}
+	return batch, nil
+}
+
+func TestReleaseDueMessages(t *testing.T) {
+	tests := []struct {
+		name      string
+		batches   []*store.DueMessages
+		wantPaths []string
+	}{
+		{
+			name:    "nothing due",
+			batches: []*store.DueMessages{{}},
+		},
+		{
+			name:    "partial batch",
+			batches: []*store.DueMessages{{Dropped: 1, Held: 2}},
+		},
+		{
+			name: "dropped and held messages count towards a full batch",
+			batches: []*store.DueMessages{
+				{Dropped: scheduledReleaseBatchSize - 1, Held: 1, Paths: []string{"user-1-a"}},
+				{Dropped: 1, Paths: []string{"user-1-b"}},
+			},
+			wantPaths: []string{"user-1-a", "user-1-b"},
+		},
+		{
+			name: "full batch then nothing",
+			batches: []*store.DueMessages{
+				{Held: scheduledReleaseBatchSize},
+				{},
+			},
+		},
+		{
+			name: "error stops the release",
+			batches: []*store.DueMessages{
+				{Dropped: scheduledReleaseBatchSize, Paths: []string{"user-1-a"}},
+				nil,
+			},
+			wantPaths: []string{"user-1-a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages := &dueMessages{batches: tt.batches}
+			app, remover := newTestApplication()
+			app.store.Messages = messages
+
+			app.releaseDueMessages(context.Background())
+
+			if messages.calls != len(tt.batches) {
+				t.Errorf("ReleaseDue called %d times, want %d", messages.calls, len(tt.batches))
+			}
+			if !slices.Equal(remover.deleted, tt.wantPaths) {
+				t.Errorf("deleted %v, want %v", remover.deleted, tt.wantPaths)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/9thDuck/chat_go.git/cmd/api/ws"
+	"github.com/9thDuck/chat_go.git/internal/store"
+)
+
+type sendMessageEventPayload struct {
+	ReceiverID int64 `json:"receiverId" validate:"required,gt=0"`
+	createMessagePayload
+}
+
+func (app *application) socketDispatcher() *ws.Dispatcher {
+	dispatcher := ws.NewDispatcher()
+	dispatcher.Handle(ws.EVENT_SEND_MESSAGE, app.sendMessageEventHandler)
+	dispatcher.Handle(ws.EVENT_ACK, app.ackEventHandler)
+	dispatcher.Handle(ws.EVENT_TYPING_START, app.typingStartEventHandler)
+	dispatcher.Handle(ws.EVENT_TYPING_STOP, app.typingStopEventHandler)
+	dispatcher.Handle(ws.EVENT_MARK_READ, app.markReadEventHandler)
+	dispatcher.HandleConnect(app.redeliverPendingMessages)
+	dispatcher.HandlePresenceChange(app.notifyContactsOfPresence)
+	return dispatcher
+}
+
+func (app *application) sendMessageEventHandler(ctx context.Context, c *ws.Client, event *ws.ClientEvent) error {
+	const payloadValidationErrMsg = "receiverId is required, content must be between 1 and 1000 characters, attachments must be an array of strings not more than 10 elements, each string must be less than 255 characters"
+
+	var payload sendMessageEventPayload
+	if err := readEventData(event, &payload); err != nil {
+		return ws.NewEventError(payloadValidationErrMsg)
+	}
+
+	senderID := c.UserID()
+	areContacts, err := app.checkContactRelationship(ctx, senderID, payload.ReceiverID)
+	if err != nil {
+		return err
+	}
+	if !areContacts {
+		return ws.NewEventError("You can only send messages to users in your contacts list")
+	}
+
+	message, err := app.createMessage(ctx, senderID, payload.ReceiverID, &payload.createMessagePayload)
+	if err == store.ErrInvalidReplyTo || err == errInvalidSendAt || err == errForeignAttachment || err == store.ErrClientMessageIDReused {
+		return ws.NewEventError(err.Error())
+	} else if err != nil && err != store.ErrMessageAlreadyExists {
+		return err
+	}
+
+	c.SendEvent(&ws.Event{
+		Type:      ws.EVENT_MESSAGE_SENT,
+		RequestID: event.RequestID,
+		Data:      message,
+	})
+	// A retried message was already delivered.
+	if err == nil {
+		app.deliverMessage(ctx, message)
+		app.syncSenderDevices(message, c)
+	}
+	return nil
+}
+
+func (app *application) ackEventHandler(ctx context.Context, c *ws.Client, event *ws.ClientEvent) error {
+	var payload ackMessagesPayload
+	if err := readEventData(event, &payload); err != nil {
+		return ws.NewEventError("messageIds must be an array of 1 to 100 message ids")
+	}
+
+	deliveredIDs, err := app.acknowledgeMessages(ctx, c.UserID(), c.StableDeviceID(), payload.MessageIDs)
+	if err != nil {
+		return err
+	}
+	app.logger.Infow("Messages acknowledged", "userID", c.UserID(), "deviceID", c.DeviceID(), "connectionID", c.ConnectionID(), "messageIDs", deliveredIDs)
+
+	c.SendEvent(&ws.Event{
+		Type:      ws.EVENT_ACKNOWLEDGED,
+		RequestID: event.RequestID,
+		Data:      &ws.AcknowledgedEventData{MessageIDs: deliveredIDs},
+	})
+	return nil
+}
+
+func (app *application) markReadEventHandler(ctx context.Context, c *ws.Client, event *ws.ClientEvent) error {
+	var payload markReadPayload
+	if err := readEventData(event, &payload); err != nil {
+		return ws.NewEventError(markReadPayloadValidationErrMsg)
+	}
+
+	reader, err := app.getUser(ctx, c.UserID())
+	if err != nil {
+		return err
+	}
+
+	readIDs, err := app.markMessagesRead(ctx, reader, &payload)
+	if err != nil {
+		return err
+	}
+
+	c.SendEvent(&ws.Event{
+		Type:      ws.EVENT_MARKED_READ,
+		RequestID: event.RequestID,
+		Data:      &ws.ReadReceiptEventData{ReaderID: reader.ID, MessageIDs: readIDs},
+	})
+	return nil
+}
+
+// readEventData decodes and validates the data of a client event, it is the
+// socket counterpart of readJson followed by Validate.Struct.
+func readEventData(event *ws.ClientEvent, target any) error {
+	if len(event.Data) == 0 {
+		return ws.NewEventError("event data is required")
+	}
+	if err := json.Unmarshal(event.Data, target); err != nil {
+		return ws.NewEventError(err.Error())
+	}
+	if err := Validate.Struct(target); err != nil {
+		return ws.NewEventError(err.Error())
+	}
+	return nil
+}
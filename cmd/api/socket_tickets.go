@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/9thDuck/chat_go.git/cmd/api/ws"
+	"github.com/9thDuck/chat_go.git/internal/store"
+)
+
+type socketTicketResponse struct {
+	Ticket    string `json:"ticket"`
+	ExpiresIn int    `json:"expiresIn"`
+}
+
+// createSocketTicketHandler issues a single use ticket the user passes as the
+// ticket query param of /v1/ws/ within ws.TicketTTL, for clients that cannot
+// send the auth cookies with the upgrade request.
+func (app *application) createSocketTicketHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+
+	ticket, err := app.tickets.Issue(r.Context(), user.ID)
+	if err != nil {
+		app.internalError(w, r, err)
+		return
+	}
+
+	app.jsonResponse(w, http.StatusCreated, &socketTicketResponse{
+		Ticket:    ticket,
+		ExpiresIn: int(ws.TicketTTL.Seconds()),
+	})
+}
+
+// socketAuthMiddleware authenticates an upgrade request by its ticket, and by
+// the auth cookies when it has none.
+func (app *application) socketAuthMiddleware(next http.Handler) http.Handler {
+	validateToken := app.ValidateTokenMiddleware()(next)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ticket := r.URL.Query().Get("ticket")
+		if ticket == "" {
+			validateToken.ServeHTTP(w, r)
+			return
+		}
+
+		ctx := r.Context()
+		userID, err := app.tickets.Redeem(ctx, ticket)
+		if err != nil {
+			if errors.Is(err, ws.ErrInvalidTicket) {
+				app.unauthorizedError(w, r, err)
+				return
+			}
+			app.internalError(w, r, err)
+			return
+		}
+
+		user, err := app.getUser(ctx, userID)
+		if err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				app.unauthorizedError(w, r, store.ErrUnautorized)
+				return
+			}
+			app.internalError(w, r, err)
+			return
+		}
+
+		ctx = context.WithValue(ctx, userCtxKey, user)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/9thDuck/chat_go.git/cmd/api/ws"
+)
+
+// typingIndicatorTimeout is how long a typing indicator stays on without the
+// client sending another TYPING_START. Clients are expected to repeat it every
+// few seconds while the user keeps typing.
+const typingIndicatorTimeout = 6 * time.Second
+
+type typingEventPayload struct {
+	ReceiverID int64 `json:"receiverId" validate:"required,gt=0"`
+}
+
+type typingKey struct {
+	senderID   int64
+	receiverID int64
+}
+
+// typingIndicators holds the expiry timer of every conversation where someone
+// is typing. Nothing is persisted.
+type typingIndicators struct {
+	mu      sync.Mutex
+	timers  map[typingKey]*time.Timer
+	timeout time.Duration
+}
+
+// newTypingIndicators turns indicators off once timeout passed without
+// another start, use typingIndicatorTimeout.
+func newTypingIndicators(timeout time.Duration) *typingIndicators {
+	return &typingIndicators{timers: make(map[typingKey]*time.Timer), timeout: timeout}
+}
+
+// start (re)arms the timer for key and reports whether the indicator was off.
+func (t *typingIndicators) start(key typingKey, onExpire func()) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	current, on := t.timers[key]
+	if on && current.Stop() {
+		current.Reset(t.timeout)
+		return false
+	}
+
+	// A timer that fired but whose callback is still waiting for the lock is
+	// replaced, the callback then finds another timer in place and relays
+	// nothing.
+	var timer *time.Timer
+	timer = time.AfterFunc(t.timeout, func() {
+		t.mu.Lock()
+		if t.timers[key] != timer {
+			t.mu.Unlock()
+			return
+		}
+		delete(t.timers, key)
+		t.mu.Unlock()
+		onExpire()
+	})
+	t.timers[key] = timer
+	return !on
+}
+
+// stop disarms the timer for key and reports whether the indicator was on.
+func (t *typingIndicators) stop(key typingKey) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	timer, ok := t.timers[key]
+	if !ok {
+		return false
+	}
+	timer.Stop()
+	delete(t.timers, key)
+	return true
+}
+
+func (app *application) typingStartEventHandler(ctx context.Context, c *ws.Client, event *ws.ClientEvent) error {
+	key, err := app.readTypingEvent(ctx, c, event)
+	if err != nil {
+		return err
+	}
+
+	if app.typing.start(key, func() { app.relayTyping(key, false) }) {
+		app.relayTyping(key, true)
+	}
+	return nil
+}
+
+func (app *application) typingStopEventHandler(ctx context.Context, c *ws.Client, event *ws.ClientEvent) error {
+	key, err := app.readTypingEvent(ctx, c, event)
+	if err != nil {
+		return err
+	}
+
+	if app.typing.stop(key) {
+		app.relayTyping(key, false)
+	}
+	return nil
+}
+
+func (app *application) readTypingEvent(ctx context.Context, c *ws.Client, event *ws.ClientEvent) (typingKey, error) {
+	var payload typingEventPayload
+	if err := readEventData(event, &payload); err != nil {
+		return typingKey{}, ws.NewEventError("receiverId is required")
+	}
+
+	areContacts, err := app.checkContactRelationship(ctx, c.UserID(), payload.ReceiverID)
+	if err != nil {
+		return typingKey{}, err
+	}
+	if !areContacts {
+		return typingKey{}, ws.NewEventError("You can only send typing indicators to users in your contacts list")
+	}
+
+	return typingKey{senderID: c.UserID(), receiverID: payload.ReceiverID}, nil
+}
+
+func (app *application) relayTyping(key typingKey, typing bool) {
+	app.socketHub.WriteToClient(key.receiverID, &ws.Event{
+		Type: ws.EVENT_TYPING,
+		Data: ws.TypingEventData{UserID: key.senderID, Typing: typing},
+	})
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+const testTypingTimeout = 100 * time.Millisecond
+
+// expiries counts the calls to the onExpire callbacks it hands out.
+type expiries chan typingKey
+
+func (e expiries) onExpire(key typingKey) func() {
+	return func() { e <- key }
+}
+
+func (e expiries) wait(t *testing.T, want typingKey) {
+	t.Helper()
+	select {
+	case got := <-e:
+		if got != want {
+			t.Fatalf("indicator of %v expired, want %v", got, want)
+		}
+	case <-time.After(10 * testTypingTimeout):
+		t.Fatalf("indicator of %v did not expire", want)
+	}
+}
+
+func (e expiries) none(t *testing.T, within time.Duration) {
+	t.Helper()
+	select {
+	case key := <-e:
+		t.Fatalf("indicator of %v expired", key)
+	case <-time.After(within):
+	}
+}
+
+func TestTypingIndicatorsStartStop(t *testing.T) {
+	key := typingKey{senderID: 1, receiverID: 2}
+	other := typingKey{senderID: 2, receiverID: 1}
+
+	tests := []struct {
+		name string
+		run  func(*typingIndicators, expiries) bool
+		want bool
+	}{
+		{"first start turns on", func(ti *typingIndicators, e expiries) bool {
+			return ti.start(key, e.onExpire(key))
+		}, true},
+		{"repeated start stays on", func(ti *typingIndicators, e expiries) bool {
+			ti.start(key, e.onExpire(key))
+			return ti.start(key, e.onExpire(key))
+		}, false},
+		{"start in the other direction turns on", func(ti *typingIndicators, e expiries) bool {
+			ti.start(key, e.onExpire(key))
+			return ti.start(other, e.onExpire(other))
+		}, true},
+		{"stop after start turns off", func(ti *typingIndicators, e expiries) bool {
+			ti.start(key, e.onExpire(key))
+			return ti.stop(key)
+		}, true},
+		{"stop without start", func(ti *typingIndicators, e expiries) bool {
+			return ti.stop(key)
+		}, false},
+		{"second stop", func(ti *typingIndicators, e expiries) bool {
+			ti.start(key, e.onExpire(key))
+			ti.stop(key)
+			return ti.stop(key)
+		}, false},
+		{"start after stop turns on", func(ti *typingIndicators, e expiries) bool {
+			ti.start(key, e.onExpire(key))
+			ti.stop(key)
+			return ti.start(key, e.onExpire(key))
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ti := newTypingIndicators(time.Hour)
+			if got := tt.run(ti, make(expiries, 2)); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTypingIndicatorsExpire(t *testing.T) {
+	key := typingKey{senderID: 1, receiverID: 2}
+	ti := newTypingIndicators(testTypingTimeout)
+	e := make(expiries, 2)
+
+	ti.start(key, e.onExpire(key))
+	e.wait(t, key)
+	e.none(t, 2*testTypingTimeout)
+
+	if ti.stop(key) {
+		t.Error("stop reported an expired indicator as on")
+	}
+	if !ti.start(key, e.onExpire(key)) {
+		t.Error("start after expiry did not turn the indicator on")
+	}
+}
+
+func TestTypingIndicatorsRestartPostponesExpiry(t *testing.T) {
+	key := typingKey{senderID: 1, receiverID: 2}
+	ti := newTypingIndicators(testTypingTimeout)
+	e := make(expiries, 2)
+
+	ti.start(key, e.onExpire(key))
+	for range 4 {
+		time.Sleep(testTypingTimeout / 2)
+		ti.start(key, e.onExpire(key))
+	}
+	e.none(t, testTypingTimeout/4)
+	e.wait(t, key)
+}
+
+func TestTypingIndicatorsStopCancelsExpiry(t *testing.T) {
+	key := typingKey{senderID: 1, receiverID: 2}
+	ti := newTypingIndicators(testTypingTimeout)
+	e := make(expiries, 2)
+
+	ti.start(key, e.onExpire(key))
+	ti.stop(key)
+	e.none(t, 3*testTypingTimeout)
+}
@@ -15,9 +15,10 @@ const (
 )
 
 type UpdateUserPayload struct {
-	FirstName  string `json:"firstName" validate:"min=0,max=30"`
-	LastName   string `json:"lastName" validate:"min=0,max=30"`
-	ProfilePic string `json:"profilePic" validate:"min=0,max=255"`
+	FirstName           string `json:"firstName" validate:"min=0,max=30"`
+	LastName            string `json:"lastName" validate:"min=0,max=30"`
+	ProfilePic          string `json:"profilePic" validate:"min=0,max=255"`
+	ReadReceiptsEnabled *bool  `json:"readReceiptsEnabled"`
 }
 
 // going to be unused
@@ -68,7 +69,7 @@ func (app *application) searchUsersByUsernamesAndGetIDsHandler(w http.ResponseWr
 
 	switch err {
 	case nil:
-		if err := app.jsonResponse(w, http.StatusOK, paginatedEnvelope{Records: &userDataForAddContactSlice, TotalRecords: total}); err != nil {
+		if err := app.jsonResponse(w, http.StatusOK, paginatedEnvelope{Records: &userDataForAddContactSlice, TotalRecords: &total}); err != nil {
 			app.internalError(w, r, err)
 			return
 		}
@@ -112,6 +113,9 @@ func (app *application) updateUserByIDHandler(w http.ResponseWriter, r *http.Req
 	user.FirstName = payload.FirstName
 	user.LastName = payload.LastName
 	user.ProfilePic = payload.ProfilePic
+	if payload.ReadReceiptsEnabled != nil {
+		user.ReadReceiptsEnabled = *payload.ReadReceiptsEnabled
+	}
 
 	ctx := r.Context()
 
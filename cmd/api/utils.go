@@ -5,15 +5,31 @@ import (
 	"errors"
 	"io"
 	"net/http"
+
+	"github.com/9thDuck/chat_go.git/internal/store"
 )
 
 type dataEnvelope struct {
 	Data any `json:"data"`
 }
 
+// paginatedEnvelope wraps a page of records. Offset listings always set
+// TotalRecords, keyset listings only when include_total is asked for and set
+// the cursors of the neighbouring pages instead.
 type paginatedEnvelope struct {
-	Records      any `json:"records"`
-	TotalRecords int `json:"total_records"`
+	Records      any    `json:"records"`
+	TotalRecords *int   `json:"total_records,omitempty"`
+	NextCursor   string `json:"next_cursor,omitempty"`
+	PrevCursor   string `json:"prev_cursor,omitempty"`
+}
+
+func newCursorEnvelope(records any, page *store.CursorPage) *paginatedEnvelope {
+	return &paginatedEnvelope{
+		Records:      records,
+		TotalRecords: page.Total,
+		NextCursor:   page.Next,
+		PrevCursor:   page.Prev,
+	}
 }
 
 type errorEnvelope struct {
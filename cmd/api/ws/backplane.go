@@ -0,0 +1,41 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// Backplane connects the hubs of every API instance. Frames published by one
+// node are handed to the hubs of all other nodes, which deliver them to their
+// local connections.
+type Backplane interface {
+	Publish(ctx context.Context, message *BackplaneMessage) error
+	// Subscribe calls fn for every message published by another node until ctx
+	// is done.
+	Subscribe(ctx context.Context, fn func(*BackplaneMessage)) error
+}
+
+// BackplaneMessage is what travels between nodes. A zero UserID means the
+// frame is broadcast to everyone, Seq is the sequence number of the frame.
+type BackplaneMessage struct {
+	NodeID string          `json:"nodeId"`
+	UserID int64           `json:"userId,omitempty"`
+	Seq    int64           `json:"seq,omitempty"`
+	Frame  json.RawMessage `json:"frame"`
+}
+
+// LocalBackplane is the single node backplane, there is nobody to publish to.
+type LocalBackplane struct{}
+
+func NewLocalBackplane() *LocalBackplane {
+	return &LocalBackplane{}
+}
+
+func (b *LocalBackplane) Publish(ctx context.Context, message *BackplaneMessage) error {
+	return nil
+}
+
+func (b *LocalBackplane) Subscribe(ctx context.Context, fn func(*BackplaneMessage)) error {
+	<-ctx.Done()
+	return nil
+}
@@ -0,0 +1,60 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const redisBackplaneChannel = "ws:hub"
+
+// RedisBackplane routes hub traffic between nodes over Redis pub/sub.
+type RedisBackplane struct {
+	db     *redis.Client
+	nodeID string
+}
+
+func NewRedisBackplane(rdb *redis.Client, nodeID string) *RedisBackplane {
+	return &RedisBackplane{db: rdb, nodeID: nodeID}
+}
+
+func (b *RedisBackplane) Publish(ctx context.Context, message *BackplaneMessage) error {
+	message.NodeID = b.nodeID
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	return b.db.Publish(ctx, redisBackplaneChannel, data).Err()
+}
+
+func (b *RedisBackplane) Subscribe(ctx context.Context, fn func(*BackplaneMessage)) error {
+	sub := b.db.Subscribe(ctx, redisBackplaneChannel)
+	defer sub.Close()
+
+	if _, err := sub.Receive(ctx); err != nil {
+		return err
+	}
+
+	ch := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			var message BackplaneMessage
+			if err := json.Unmarshal([]byte(msg.Payload), &message); err != nil {
+				log.Printf("error decoding backplane message: %v", err)
+				continue
+			}
+			if message.NodeID == b.nodeID {
+				continue
+			}
+			fn(&message)
+		}
+	}
+}
@@ -1,42 +1,186 @@
 package ws
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"log"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+// transport carries frames to the peer of a client, over a WebSocket or an
+// SSE stream.
+type transport interface {
+	writeFrame(frame SequencedFrame) error
+	writePing() error
+	writeClose(code int, reason string) error
+	// Close releases the connection, ending the read side as well.
+	Close() error
+}
+
 type Client struct {
-	conn *websocket.Conn
-	send chan []byte
+	conn transport
+	// send is bounded, a client that lets it fill up is disconnected instead
+	// of blocking whoever is writing to it. It is never closed, done is closed
+	// instead once the client must stop.
+	send chan SequencedFrame
 	hub  *Hub
 	id   int64
+	// connID identifies this connection, deviceID the device it was opened
+	// from. A device reconnecting gets a new connID but keeps its deviceID.
+	connID   string
+	deviceID string
+
+	// registered is closed by the hub once the client receives live events.
+	registered chan struct{}
+	// backlog is written before anything queued on send, frames queued with a
+	// seq up to replayedSeq were part of it and are skipped. Both are set
+	// before the write pump starts.
+	backlog     []SequencedFrame
+	replayedSeq int64
+
+	done        chan struct{}
+	closeOnce   sync.Once
+	closeCode   int
+	closeReason string
+}
+
+func newClient(hub *Hub, conn transport, userID int64, connID, deviceID string) *Client {
+	return &Client{
+		hub:        hub,
+		conn:       conn,
+		send:       make(chan SequencedFrame, sendQueueSize),
+		id:         userID,
+		connID:     connID,
+		deviceID:   deviceID,
+		registered: make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+// resume prepares the backlog of a client that last saw lastSeq, a negative
+// lastSeq means the client starts afresh. It must run after the client is
+// registered, so no event falls between the replay and live traffic.
+func (c *Client) resume(ctx context.Context, lastSeq int64) {
+	connected := ConnectedEventData{ConnectionID: c.connID, DeviceID: c.deviceID}
+
+	var replay []SequencedFrame
+	if lastSeq >= 0 {
+		frames, complete, err := c.hub.events.Since(ctx, c.id, lastSeq)
+		if err != nil {
+			log.Printf("error reading event log of user %d: %v", c.id, err)
+		}
+		replay = frames
+		connected.Replayed = len(frames)
+		connected.ResyncRequired = err != nil || !complete
+	}
+
+	message, err := json.Marshal(&Event{Type: EVENT_CONNECTED, Data: connected})
+	if err != nil {
+		log.Printf("error marshalling %s event: %v", EVENT_CONNECTED, err)
+		return
+	}
+	c.backlog = append(c.backlog, SequencedFrame{Frame: message})
+	for _, frame := range replay {
+		c.backlog = append(c.backlog, frame)
+		c.replayedSeq = frame.Seq
+	}
 }
 
-func (c *Client) readMessages() {
+func (c *Client) readMessages(conn wsTransport) {
 	defer func() {
-		c.conn.Close()
-		c.hub.unregister <- c
+		conn.Close()
+		c.hub.unregisterClient(c)
+		c.hub.connections.Done()
 	}()
-	c.conn.SetReadLimit(maxMessageSize)
-	c.conn.SetReadDeadline(time.Now().Add(pongWait))
-	c.conn.SetPongHandler(func(string) error { c.conn.SetReadDeadline(time.Now().Add(pongWait)); return nil })
+	conn.SetReadLimit(maxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error { conn.SetReadDeadline(time.Now().Add(pongWait)); return nil })
 	for {
-		_, messageBytes, err := c.conn.ReadMessage()
+		_, messageBytes, err := conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("error reading message: %v", err)
 			}
 			break
 		}
-		message := strings.TrimSpace(string(messageBytes))
-		if message == "" {
+		if len(bytes.TrimSpace(messageBytes)) == 0 {
+			continue
+		}
+		frame, err := conn.codec.Decode(messageBytes)
+		if err != nil {
+			c.SendEvent(errorEvent("", "malformed event, could not decode "+conn.codec.Subprotocol()+" frame"))
 			continue
 		}
-		c.hub.broadcast <- []byte(message)
+		c.hub.dispatcher.dispatch(context.Background(), c, frame)
+	}
+}
+
+func (c *Client) UserID() int64 {
+	return c.id
+}
+
+func (c *Client) ConnectionID() string {
+	return c.connID
+}
+
+func (c *Client) DeviceID() string {
+	return c.deviceID
+}
+
+// StableDeviceID returns the device id the client passed when connecting, or
+// "" when the connection id doubles as the device id.
+func (c *Client) StableDeviceID() string {
+	if c.deviceID == c.connID {
+		return ""
+	}
+	return c.deviceID
+}
+
+// SendEvent queues event for this connection only.
+func (c *Client) SendEvent(event *Event) {
+	message, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("error marshalling %s event: %v", event.Type, err)
+		return
 	}
+	c.enqueue(SequencedFrame{Frame: message})
+}
+
+// enqueue never blocks. When the send queue is full the frame is dropped and
+// the client is disconnected, it will catch up on what it missed when it
+// reconnects.
+func (c *Client) enqueue(message SequencedFrame) bool {
+	select {
+	case <-c.done:
+		c.hub.metrics.droppedFrames.Add(1)
+		return false
+	default:
+	}
+
+	select {
+	case c.send <- message:
+		return true
+	default:
+		c.hub.metrics.droppedFrames.Add(1)
+		c.hub.metrics.evictions.Add(1)
+		log.Printf("evicting slow client %d (%s), send queue full", c.id, c.connID)
+		c.close(websocket.ClosePolicyViolation, "send queue full")
+		return false
+	}
+}
+
+// close stops the write pump, which sends a close frame with code and reason
+// to the peer. Only the first call has any effect.
+func (c *Client) close(code int, reason string) {
+	c.closeOnce.Do(func() {
+		c.closeCode = code
+		c.closeReason = reason
+		close(c.done)
+	})
 }
 
 func (c *Client) writeMessages() {
@@ -44,29 +188,86 @@ func (c *Client) writeMessages() {
 	defer func() {
 		ticker.Stop()
 		c.conn.Close()
+		c.hub.connections.Done()
 	}()
+	for _, message := range c.backlog {
+		if err := c.conn.writeFrame(message); err != nil {
+			log.Printf("error writing message: %v", err)
+			return
+		}
+	}
+	c.backlog = nil
 	for {
 		select {
-		case message, ok := <-c.send:
-			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if !ok {
-				// The hub closed the channel.
-				if err := c.conn.WriteMessage(websocket.CloseMessage, nil); err != nil {
-					log.Printf("error writing close message: %v", err)
-				}
-				return
+		case <-c.done:
+			if c.closeCode == websocket.CloseGoingAway {
+				c.flush()
 			}
-
-			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+			if err := c.conn.writeClose(c.closeCode, c.closeReason); err != nil {
+				log.Printf("error writing close message: %v", err)
+			}
+			return
+		case message := <-c.send:
+			if err := c.write(message); err != nil {
 				log.Printf("error writing message: %v", err)
 				return
 			}
 		case <-ticker.C:
-			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+			if err := c.conn.writePing(); err != nil {
 				log.Printf("error writing ping message: %v", err)
 				return
 			}
 		}
 	}
 }
+
+// flush writes whatever is left in the send queue without waiting for more.
+func (c *Client) flush() {
+	for {
+		select {
+		case message := <-c.send:
+			if err := c.write(message); err != nil {
+				log.Printf("error flushing message: %v", err)
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// write sends a queued frame unless it was already replayed.
+func (c *Client) write(message SequencedFrame) error {
+	if message.Seq != 0 && message.Seq <= c.replayedSeq {
+		return nil
+	}
+	return c.conn.writeFrame(message)
+}
+
+// wsTransport writes to a WebSocket connection in the encoding of codec, its
+// read side is handled by Client.readMessages.
+type wsTransport struct {
+	*websocket.Conn
+	codec Codec
+}
+
+func (t wsTransport) writeFrame(frame SequencedFrame) error {
+	data, err := t.codec.Encode(frame.Frame)
+	if err != nil {
+		// One frame the codec cannot carry is no reason to drop the client.
+		log.Printf("error encoding frame as %s: %v", t.codec.Subprotocol(), err)
+		return nil
+	}
+	t.SetWriteDeadline(time.Now().Add(writeWait))
+	return t.WriteMessage(t.codec.MessageType(), data)
+}
+
+func (t wsTransport) writePing() error {
+	t.SetWriteDeadline(time.Now().Add(writeWait))
+	return t.WriteMessage(websocket.PingMessage, nil)
+}
+
+func (t wsTransport) writeClose(code int, reason string) error {
+	t.SetWriteDeadline(time.Now().Add(writeWait))
+	return t.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason))
+}
@@ -0,0 +1,83 @@
+package ws
+
+import "github.com/gorilla/websocket"
+
+const (
+	SUBPROTOCOL_JSON    = "duckchat.json.v1"
+	SUBPROTOCOL_MSGPACK = "duckchat.msgpack.v1"
+)
+
+// Codec translates between JSON, the canonical encoding of events used by the
+// hub, the event log and the backplane, and the encoding a client negotiated
+// through the WebSocket subprotocol.
+type Codec interface {
+	Subprotocol() string
+	// MessageType is the WebSocket frame type encoded frames are sent as.
+	MessageType() int
+	// Encode turns a JSON frame into its wire form.
+	Encode(frame []byte) ([]byte, error)
+	// Decode turns a frame received from the client into JSON.
+	Decode(data []byte) ([]byte, error)
+}
+
+// codecs are listed in order of preference, a client offering several gets
+// the first one it supports.
+var codecs = []Codec{msgpackCodec{}, jsonCodec{}}
+
+func subprotocols() []string {
+	names := make([]string, len(codecs))
+	for i, codec := range codecs {
+		names[i] = codec.Subprotocol()
+	}
+	return names
+}
+
+// codecFor returns the codec of a negotiated subprotocol. Clients that did
+// not ask for one speak JSON.
+func codecFor(subprotocol string) Codec {
+	for _, codec := range codecs {
+		if codec.Subprotocol() == subprotocol {
+			return codec
+		}
+	}
+	return jsonCodec{}
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Subprotocol() string {
+	return SUBPROTOCOL_JSON
+}
+
+func (jsonCodec) MessageType() int {
+	return websocket.TextMessage
+}
+
+func (jsonCodec) Encode(frame []byte) ([]byte, error) {
+	return frame, nil
+}
+
+func (jsonCodec) Decode(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+// msgpackCodec sends events as MessagePack binary frames. Clients may send
+// ciphertext as MessagePack bin instead of base64, it is base64 encoded on the
+// way in so handlers see the same payload either way.
+type msgpackCodec struct{}
+
+func (msgpackCodec) Subprotocol() string {
+	return SUBPROTOCOL_MSGPACK
+}
+
+func (msgpackCodec) MessageType() int {
+	return websocket.BinaryMessage
+}
+
+func (msgpackCodec) Encode(frame []byte) ([]byte, error) {
+	return jsonToMsgpack(frame)
+}
+
+func (msgpackCodec) Decode(data []byte) ([]byte, error) {
+	return msgpackToJSON(data)
+}
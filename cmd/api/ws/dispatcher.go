@@ -0,0 +1,110 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+)
+
+const defaultEventErrMsg = "something went wrong"
+
+// HandlerFunc handles a single event sent by a client. Returning an
+// *EventError sends its message back to the client, any other error is logged
+// and answered with a generic error frame.
+type HandlerFunc func(ctx context.Context, c *Client, event *ClientEvent) error
+
+// ConnectHandlerFunc is called once a client has been registered with the hub.
+type ConnectHandlerFunc func(ctx context.Context, c *Client)
+
+// PresenceHandlerFunc is called when a user comes online on their first
+// connection or goes offline with their last.
+type PresenceHandlerFunc func(ctx context.Context, presence Presence)
+
+type EventError struct {
+	Message string
+}
+
+func (e *EventError) Error() string {
+	return e.Message
+}
+
+func NewEventError(msg string) *EventError {
+	return &EventError{Message: msg}
+}
+
+type Dispatcher struct {
+	handlers         map[string]HandlerFunc
+	onConnect        []ConnectHandlerFunc
+	onPresenceChange []PresenceHandlerFunc
+}
+
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{handlers: make(map[string]HandlerFunc)}
+}
+
+// Handle registers fn for eventType. It is not safe to call once the hub is
+// running.
+func (d *Dispatcher) Handle(eventType string, fn HandlerFunc) {
+	d.handlers[eventType] = fn
+}
+
+// HandleConnect registers fn to run for every new connection. It is not safe
+// to call once the hub is running.
+func (d *Dispatcher) HandleConnect(fn ConnectHandlerFunc) {
+	d.onConnect = append(d.onConnect, fn)
+}
+
+func (d *Dispatcher) connected(ctx context.Context, c *Client) {
+	for _, fn := range d.onConnect {
+		fn(ctx, c)
+	}
+}
+
+// HandlePresenceChange registers fn to run whenever a user's presence changes.
+// It is not safe to call once the hub is running.
+func (d *Dispatcher) HandlePresenceChange(fn PresenceHandlerFunc) {
+	d.onPresenceChange = append(d.onPresenceChange, fn)
+}
+
+func (d *Dispatcher) presenceChanged(ctx context.Context, presence Presence) {
+	for _, fn := range d.onPresenceChange {
+		fn(ctx, presence)
+	}
+}
+
+func (d *Dispatcher) dispatch(ctx context.Context, c *Client, frame []byte) {
+	var event ClientEvent
+	if err := json.Unmarshal(frame, &event); err != nil {
+		c.SendEvent(errorEvent("", "malformed event, expected a JSON object with type and data"))
+		return
+	}
+	if event.Type == "" {
+		c.SendEvent(errorEvent(event.RequestID, "event type is required"))
+		return
+	}
+
+	handler, ok := d.handlers[event.Type]
+	if !ok {
+		c.SendEvent(errorEvent(event.RequestID, "unknown event type: "+event.Type))
+		return
+	}
+
+	if err := handler(ctx, c, &event); err != nil {
+		var eventErr *EventError
+		if errors.As(err, &eventErr) {
+			c.SendEvent(errorEvent(event.RequestID, eventErr.Message))
+			return
+		}
+		log.Printf("error handling %s event from client %d: %v", event.Type, c.id, err)
+		c.SendEvent(errorEvent(event.RequestID, defaultEventErrMsg))
+	}
+}
+
+func errorEvent(requestID, msg string) *Event {
+	return &Event{
+		Type:      EVENT_ERROR,
+		RequestID: requestID,
+		Data:      ErrorEventData{Message: msg},
+	}
+}
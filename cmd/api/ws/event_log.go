@@ -0,0 +1,154 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+const (
+	// A user's logged frames are forgotten once nothing was appended for this
+	// long, clients that stay away longer must resync through the REST API.
+	eventLogRetention = 10 * time.Minute
+
+	// Frames kept per user, older ones are dropped first.
+	eventLogSize = 500
+
+	// A user's counter outlives their frames by far so their numbering does
+	// not restart while their clients still remember it.
+	seqRetention = 30 * 24 * time.Hour
+)
+
+// EventLog numbers the events pushed to every user and keeps them for a short
+// while so a reconnecting client can catch up on what it missed.
+type EventLog interface {
+	// Append assigns event the next sequence number of userID and stores its
+	// encoded frame. event itself is left untouched.
+	Append(ctx context.Context, userID int64, event *Event) (SequencedFrame, error)
+	// Since returns the frames of userID numbered after seq, oldest first. It
+	// reports false when some of them are no longer in the log.
+	Since(ctx context.Context, userID int64, seq int64) ([]SequencedFrame, bool, error)
+}
+
+type SequencedFrame struct {
+	Seq   int64
+	Frame []byte
+}
+
+// isEphemeral reports whether events of eventType only matter while they are
+// live. They are sent without a seq and never replayed.
+func isEphemeral(eventType string) bool {
+	return eventType == EVENT_TYPING || eventType == EVENT_PRESENCE
+}
+
+func encodeSequenced(event *Event, seq int64) (SequencedFrame, error) {
+	sequenced := *event
+	sequenced.Seq = seq
+	frame, err := json.Marshal(&sequenced)
+	if err != nil {
+		return SequencedFrame{}, err
+	}
+	return SequencedFrame{Seq: seq, Frame: frame}, nil
+}
+
+// isComplete reports whether frames, returned for a client that last saw seq
+// while the user's counter is at current, hold every event it missed.
+func isComplete(frames []SequencedFrame, seq, current int64) bool {
+	if seq > current {
+		// The counter was reset, the client's numbering means nothing anymore.
+		return false
+	}
+	if seq == current {
+		return true
+	}
+	return len(frames) > 0 && frames[0].Seq == seq+1
+}
+
+// MemoryEventLog keeps the event log in process, it is only accurate when a
+// single API instance is running.
+type MemoryEventLog struct {
+	mu   sync.Mutex
+	logs map[int64]*userEventLog
+	// lastSwept is when the logs of idle users were last swept.
+	lastSwept time.Time
+}
+
+type userEventLog struct {
+	seq          int64
+	frames       []SequencedFrame
+	lastAppended time.Time
+}
+
+func NewMemoryEventLog() *MemoryEventLog {
+	return &MemoryEventLog{logs: make(map[int64]*userEventLog)}
+}
+
+func (l *MemoryEventLog) Append(ctx context.Context, userID int64, event *Event) (SequencedFrame, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(time.Now())
+	log, ok := l.logs[userID]
+	if !ok {
+		log = &userEventLog{}
+		l.logs[userID] = log
+	}
+	log.prune(time.Now())
+
+	frame, err := encodeSequenced(event, log.seq+1)
+	if err != nil {
+		return SequencedFrame{}, err
+	}
+	log.seq = frame.Seq
+	log.frames = append(log.frames, frame)
+	if len(log.frames) > eventLogSize {
+		log.frames = log.frames[len(log.frames)-eventLogSize:]
+	}
+	log.lastAppended = time.Now()
+	return frame, nil
+}
+
+func (l *MemoryEventLog) Since(ctx context.Context, userID int64, seq int64) ([]SequencedFrame, bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	log, ok := l.logs[userID]
+	if !ok {
+		return []SequencedFrame{}, isComplete(nil, seq, 0), nil
+	}
+	log.prune(time.Now())
+
+	frames := []SequencedFrame{}
+	for _, frame := range log.frames {
+		if frame.Seq > seq {
+			frames = append(frames, frame)
+		}
+	}
+	return frames, isComplete(frames, seq, log.seq), nil
+}
+
+// sweep drops the frames of users idle for eventLogRetention and forgets users
+// idle for seqRetention altogether, at most once per eventLogRetention. Only
+// then do their sequence numbers start over.
+func (l *MemoryEventLog) sweep(now time.Time) {
+	if now.Sub(l.lastSwept) < eventLogRetention {
+		return
+	}
+	l.lastSwept = now
+	for userID, log := range l.logs {
+		if now.Sub(log.lastAppended) > seqRetention {
+			delete(l.logs, userID)
+			continue
+		}
+		log.prune(now)
+	}
+}
+
+// prune drops every frame once the log has been idle for eventLogRetention,
+// the sequence number carries on.
+func (log *userEventLog) prune(now time.Time) {
+	if now.Sub(log.lastAppended) > eventLogRetention {
+		log.frames = nil
+	}
+}
@@ -0,0 +1,79 @@
+package ws
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// RedisEventLog numbers events with a counter per user and logs their frames
+// in a sorted set scored by sequence number, so every node shares one log.
+type RedisEventLog struct {
+	db *redis.Client
+}
+
+func NewRedisEventLog(rdb *redis.Client) *RedisEventLog {
+	return &RedisEventLog{db: rdb}
+}
+
+func (l *RedisEventLog) Append(ctx context.Context, userID int64, event *Event) (SequencedFrame, error) {
+	seqKey := seqKey(userID)
+	pipe := l.db.TxPipeline()
+	incr := pipe.Incr(ctx, seqKey)
+	pipe.Expire(ctx, seqKey, seqRetention)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return SequencedFrame{}, err
+	}
+
+	frame, err := encodeSequenced(event, incr.Val())
+	if err != nil {
+		return SequencedFrame{}, err
+	}
+
+	key := eventLogKey(userID)
+	pipe = l.db.TxPipeline()
+	pipe.ZAdd(ctx, key, &redis.Z{Score: float64(frame.Seq), Member: frame.Frame})
+	pipe.ZRemRangeByRank(ctx, key, 0, -eventLogSize-1)
+	pipe.Expire(ctx, key, eventLogRetention)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return SequencedFrame{}, err
+	}
+	return frame, nil
+}
+
+func (l *RedisEventLog) Since(ctx context.Context, userID int64, seq int64) ([]SequencedFrame, bool, error) {
+	pipe := l.db.Pipeline()
+	current := pipe.Get(ctx, seqKey(userID))
+	entries := pipe.ZRangeByScoreWithScores(ctx, eventLogKey(userID), &redis.ZRangeBy{
+		Min: "(" + strconv.FormatInt(seq, 10),
+		Max: "+inf",
+	})
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, false, err
+	}
+
+	currentSeq, err := current.Int64()
+	if err != nil && err != redis.Nil {
+		return nil, false, err
+	}
+
+	frames := make([]SequencedFrame, len(entries.Val()))
+	for i, entry := range entries.Val() {
+		member, ok := entry.Member.(string)
+		if !ok {
+			return nil, false, fmt.Errorf("unexpected event log member %T", entry.Member)
+		}
+		frames[i] = SequencedFrame{Seq: int64(entry.Score), Frame: []byte(member)}
+	}
+	return frames, isComplete(frames, seq, currentSeq), nil
+}
+
+func seqKey(userID int64) string {
+	return fmt.Sprintf("ws:seq:%d", userID)
+}
+
+func eventLogKey(userID int64) string {
+	return fmt.Sprintf("ws:events:%d", userID)
+}
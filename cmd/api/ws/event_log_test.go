@@ -0,0 +1,127 @@
+package ws
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func framesFrom(first, last int64) []SequencedFrame {
+	frames := []SequencedFrame{}
+	for seq := first; seq <= last; seq++ {
+		frames = append(frames, SequencedFrame{Seq: seq})
+	}
+	return frames
+}
+
+func TestIsComplete(t *testing.T) {
+	tests := []struct {
+		name    string
+		frames  []SequencedFrame
+		seq     int64
+		current int64
+		want    bool
+	}{
+		{"up to date", nil, 5, 5, true},
+		{"fresh client, empty log", nil, 0, 0, true},
+		{"every missed frame", framesFrom(6, 9), 5, 9, true},
+		{"fresh client, every frame", framesFrom(1, 3), 0, 3, true},
+		{"oldest missed frame dropped", framesFrom(7, 9), 5, 9, false},
+		{"every missed frame dropped", nil, 5, 9, false},
+		{"counter reset", nil, 9, 2, false},
+		{"counter reset, log empty", nil, 3, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isComplete(tt.frames, tt.seq, tt.current); got != tt.want {
+				t.Errorf("isComplete(%d frames, %d, %d) = %v, want %v", len(tt.frames), tt.seq, tt.current, got, tt.want)
+			}
+		})
+	}
+}
+
+func appendEvents(t *testing.T, l *MemoryEventLog, userID int64, n int) {
+	t.Helper()
+	for range n {
+		if _, err := l.Append(context.Background(), userID, &Event{Type: EVENT_MESSAGE}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMemoryEventLogSince(t *testing.T) {
+	l := NewMemoryEventLog()
+	appendEvents(t, l, 1, 3)
+
+	frames, complete, err := l.Since(context.Background(), 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !complete || len(frames) != 2 || frames[0].Seq != 2 || frames[1].Seq != 3 {
+		t.Errorf("Since(1) = %v, %v, want seqs 2 and 3, complete", frames, complete)
+	}
+
+	if _, complete, _ := l.Since(context.Background(), 2, 0); !complete {
+		t.Error("Since(0) for a user without events is incomplete")
+	}
+}
+
+func TestMemoryEventLogDropsOldestFrames(t *testing.T) {
+	l := NewMemoryEventLog()
+	appendEvents(t, l, 1, eventLogSize+2)
+
+	frames, complete, err := l.Since(context.Background(), 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if complete {
+		t.Error("Since(1) is complete although frame 2 was dropped")
+	}
+	if len(frames) != eventLogSize || frames[0].Seq != 3 {
+		t.Errorf("Since(1) returned %d frames from seq %d, want %d from seq 3", len(frames), frames[0].Seq, eventLogSize)
+	}
+}
+
+// A sweep must not restart the numbering of an idle user: a client that last
+// saw seq 5 would otherwise take the new seqs 1 to 5 for events it already got.
+func TestMemoryEventLogSweepKeepsCounter(t *testing.T) {
+	l := NewMemoryEventLog()
+	appendEvents(t, l, 1, 5)
+	appendEvents(t, l, 2, 1)
+
+	l.logs[1].lastAppended = time.Now().Add(-2 * eventLogRetention)
+	l.lastSwept = time.Time{}
+	appendEvents(t, l, 2, 1)
+	if len(l.logs[1].frames) != 0 {
+		t.Fatalf("sweep kept %d frames of an idle user", len(l.logs[1].frames))
+	}
+
+	appendEvents(t, l, 1, 4)
+	frames, complete, err := l.Since(context.Background(), 1, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !complete || len(frames) != 4 || frames[0].Seq != 6 {
+		t.Errorf("Since(5) = %v, %v, want seqs 6 to 9, complete", frames, complete)
+	}
+
+	if _, complete, _ := l.Since(context.Background(), 1, 3); complete {
+		t.Error("Since(3) is complete although the frames of seqs 4 and 5 were swept")
+	}
+}
+
+func TestMemoryEventLogSweepForgetsLongIdleUsers(t *testing.T) {
+	l := NewMemoryEventLog()
+	appendEvents(t, l, 1, 5)
+
+	l.logs[1].lastAppended = time.Now().Add(-seqRetention - time.Hour)
+	l.lastSwept = time.Time{}
+	appendEvents(t, l, 2, 1)
+	if _, ok := l.logs[1]; ok {
+		t.Fatal("sweep kept the counter of a user idle for longer than seqRetention")
+	}
+
+	if _, complete, _ := l.Since(context.Background(), 1, 5); complete {
+		t.Error("Since(5) is complete although the counter was reset")
+	}
+}
@@ -1,64 +1,353 @@
 package ws
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
 )
 
 type Hub struct {
-	clients          map[*Client]bool
-	clientsWithIDKey map[int64]*Client
+	clients map[*Client]bool
+	// clientsWithIDKey holds every open connection of a user, one per device
+	// or tab.
+	clientsWithIDKey map[int64]map[*Client]bool
 	register         chan *Client
 	unregister       chan *Client
 	broadcast        chan []byte
-	writeToClient    chan []byte
+	dispatcher       *Dispatcher
+	backplane        Backplane
+	presence         PresenceStore
+	events           EventLog
+	metrics          hubMetrics
+	// connections counts the read and write pumps of every client so
+	// Shutdown can wait for them.
+	connections  sync.WaitGroup
+	shuttingDown bool
+	// stopped is closed once Run returns, nothing receives from unregister
+	// after that.
+	stopped chan struct{}
 	sync.RWMutex
 }
 
-func NewHub() *Hub {
+const shutdownCloseReason = "server shutting down"
+
+type hubMetrics struct {
+	evictions     atomic.Int64
+	droppedFrames atomic.Int64
+}
+
+// HubStats is a point in time snapshot of the hub's local connections.
+type HubStats struct {
+	Connections int `json:"connections"`
+	Users       int `json:"users"`
+	// QueuedFrames is the sum of every send queue, MaxQueueDepth the fullest.
+	QueuedFrames  int   `json:"queuedFrames"`
+	MaxQueueDepth int   `json:"maxQueueDepth"`
+	QueueCapacity int   `json:"queueCapacity"`
+	Evictions     int64 `json:"evictions"`
+	DroppedFrames int64 `json:"droppedFrames"`
+}
+
+// NewHub creates a hub that relays events for other API instances through
+// backplane, use NewLocalBackplane when running a single instance. presence
+// and events must be shared by every instance as well.
+func NewHub(dispatcher *Dispatcher, backplane Backplane, presence PresenceStore, events EventLog) *Hub {
 	return &Hub{
 		clients:          make(map[*Client]bool),
-		clientsWithIDKey: make(map[int64]*Client),
+		clientsWithIDKey: make(map[int64]map[*Client]bool),
 		register:         make(chan *Client),
 		unregister:       make(chan *Client),
 		broadcast:        make(chan []byte),
-		writeToClient:    make(chan []byte),
+		dispatcher:       dispatcher,
+		backplane:        backplane,
+		presence:         presence,
+		events:           events,
+		stopped:          make(chan struct{}),
 	}
 }
 
-func (h *Hub) Run() {
+// Run processes registrations until ctx is done. Cancel it only after
+// Shutdown has returned, clients unregister through Run while closing.
+func (h *Hub) Run(ctx context.Context) {
+	defer close(h.stopped)
+
+	go func() {
+		if err := h.backplane.Subscribe(ctx, h.deliverRemote); err != nil {
+			fmt.Printf("hub backplane subscription ended: %v\n", err)
+		}
+	}()
+
 	for {
 		select {
+		case <-ctx.Done():
+			return
 		case client := <-h.register:
 			h.Lock()
+			if h.shuttingDown {
+				h.Unlock()
+				client.close(websocket.CloseGoingAway, shutdownCloseReason)
+				close(client.registered)
+				continue
+			}
 			h.clients[client] = true
-			h.clientsWithIDKey[client.id] = client
-			client.send <- []byte("Welcome to the chat!")
+			if _, ok := h.clientsWithIDKey[client.id]; !ok {
+				h.clientsWithIDKey[client.id] = make(map[*Client]bool)
+			}
+			h.clientsWithIDKey[client.id][client] = true
 			h.Unlock()
+			close(client.registered)
+			first, err := h.presence.Connect(ctx, client.id, client.connID)
+			if err != nil {
+				fmt.Printf("error recording presence of connection %s: %v\n", client.connID, err)
+			}
+			go h.dispatcher.connected(ctx, client)
+			if first {
+				go h.dispatcher.presenceChanged(ctx, Presence{UserID: client.id, Online: true})
+			}
 		case client := <-h.unregister:
 			if _, ok := h.clients[client]; ok {
 				h.Lock()
 				delete(h.clients, client)
-				delete(h.clientsWithIDKey, client.id)
-				fmt.Println("client unregistered", client.id)
-				client.send <- []byte("You have been disconnected from the chat.")
-				client.conn.Close()
+				delete(h.clientsWithIDKey[client.id], client)
+				if len(h.clientsWithIDKey[client.id]) == 0 {
+					delete(h.clientsWithIDKey, client.id)
+				}
 				h.Unlock()
+				client.close(websocket.CloseNormalClosure, "")
+				lastSeen := time.Now().UTC()
+				last, err := h.presence.Disconnect(ctx, client.id, client.connID, lastSeen)
+				if err != nil {
+					fmt.Printf("error recording presence of connection %s: %v\n", client.connID, err)
+				}
+				if last {
+					go h.dispatcher.presenceChanged(ctx, Presence{UserID: client.id, Online: false, LastSeen: &lastSeen})
+				}
 			}
 		case message := <-h.broadcast:
+			h.RLock()
 			for client := range h.clients {
-				client.send <- message
+				client.enqueue(SequencedFrame{Frame: message})
 			}
+			h.RUnlock()
 		}
 	}
 }
 
-func (h *Hub) WriteToClient(receiverID int64, message []byte) bool {
-	client, ok := h.clientsWithIDKey[receiverID]
+// WriteToClient numbers event in the event log of receiverID and sends it to
+// every connection of receiverID on this node and through the backplane on
+// every other node. Ephemeral events, typing and presence, skip the log. It
+// reports whether the receiver has at least one open connection anywhere.
+func (h *Hub) WriteToClient(receiverID int64, event *Event) bool {
+	return h.writeToClient(receiverID, event, nil)
+}
+
+// WriteToClientExcept is WriteToClient but skips except, a connection of
+// receiverID that was already sent event some other way.
+func (h *Hub) WriteToClientExcept(receiverID int64, event *Event, except *Client) bool {
+	return h.writeToClient(receiverID, event, except)
+}
+
+func (h *Hub) writeToClient(receiverID int64, event *Event, except *Client) bool {
+	ctx := context.Background()
+	message, ok := h.frameFor(ctx, receiverID, event)
 	if !ok {
-		fmt.Printf("writing to socket client failed, client not found: %v", receiverID)
 		return false
 	}
-	client.send <- message
+
+	if err := h.backplane.Publish(ctx, &BackplaneMessage{UserID: receiverID, Seq: message.Seq, Frame: message.Frame}); err != nil {
+		fmt.Printf("error publishing %s event to backplane: %v\n", event.Type, err)
+	}
+
+	if h.writeToLocalClients(receiverID, message, except) || except != nil {
+		return true
+	}
+
+	online, err := h.IsOnline(ctx, receiverID)
+	if err != nil {
+		fmt.Printf("error checking if user %d is online: %v\n", receiverID, err)
+		return false
+	}
+	return online
+}
+
+// frameFor numbers event in the event log of receiverID unless it is ephemeral
+// and returns its frame.
+func (h *Hub) frameFor(ctx context.Context, receiverID int64, event *Event) (SequencedFrame, bool) {
+	if !isEphemeral(event.Type) {
+		message, err := h.events.Append(ctx, receiverID, event)
+		if err == nil {
+			return message, true
+		}
+		fmt.Printf("error logging %s event: %v\n", event.Type, err)
+		// Still deliver it to whoever is connected, just without a seq.
+	}
+	frame, err := json.Marshal(event)
+	if err != nil {
+		fmt.Printf("error marshalling %s event: %v\n", event.Type, err)
+		return SequencedFrame{}, false
+	}
+	return SequencedFrame{Frame: frame}, true
+}
+
+// Broadcast sends event to every connected client on every node.
+func (h *Hub) Broadcast(event *Event) {
+	message, err := json.Marshal(event)
+	if err != nil {
+		fmt.Printf("error marshalling %s event: %v\n", event.Type, err)
+		return
+	}
+
+	if err := h.backplane.Publish(context.Background(), &BackplaneMessage{Frame: message}); err != nil {
+		fmt.Printf("error publishing %s event to backplane: %v\n", event.Type, err)
+	}
+	h.broadcastLocal(message)
+}
+
+// broadcastLocal hands frame to Run to be sent to every client on this node.
+// Once Run has returned the frame is dropped, no client is left to get it.
+func (h *Hub) broadcastLocal(frame []byte) {
+	select {
+	case h.broadcast <- frame:
+	case <-h.stopped:
+	}
+}
+
+// acquire counts the read and write pumps of a new connection so Shutdown
+// waits for them. It reports false once the hub is shutting down, the
+// connection must not be opened then.
+func (h *Hub) acquire() bool {
+	h.Lock()
+	defer h.Unlock()
+	if h.shuttingDown {
+		return false
+	}
+	h.connections.Add(2)
 	return true
 }
+
+// release undoes acquire for a connection that failed to open.
+func (h *Hub) release() {
+	h.connections.Add(-2)
+}
+
+// registerClient hands client to Run to be registered and waits until it is.
+// Should Run have returned, client is closed instead.
+func (h *Hub) registerClient(client *Client) {
+	select {
+	case h.register <- client:
+		<-client.registered
+	case <-h.stopped:
+		client.close(websocket.CloseGoingAway, shutdownCloseReason)
+	}
+}
+
+// unregisterClient hands client to Run to be unregistered, unless Run has
+// already returned.
+func (h *Hub) unregisterClient(client *Client) {
+	select {
+	case h.unregister <- client:
+	case <-h.stopped:
+	}
+}
+
+// Shutdown asks every client to go away once the frames already queued for it
+// are flushed, then waits for their connections to close or ctx to be done.
+func (h *Hub) Shutdown(ctx context.Context) error {
+	h.Lock()
+	h.shuttingDown = true
+	for client := range h.clients {
+		client.close(websocket.CloseGoingAway, shutdownCloseReason)
+	}
+	h.Unlock()
+
+	closed := make(chan struct{})
+	go func() {
+		h.connections.Wait()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// CloseStreams asks every SSE client to go away. Streams are ordinary HTTP
+// requests that never finish, the HTTP server can only shut down once they end.
+func (h *Hub) CloseStreams() {
+	h.RLock()
+	defer h.RUnlock()
+	for client := range h.clients {
+		if client.isStream() {
+			client.close(websocket.CloseGoingAway, shutdownCloseReason)
+		}
+	}
+}
+
+func (h *Hub) Stats() HubStats {
+	h.RLock()
+	defer h.RUnlock()
+
+	stats := HubStats{
+		Connections:   len(h.clients),
+		Users:         len(h.clientsWithIDKey),
+		QueueCapacity: sendQueueSize,
+		Evictions:     h.metrics.evictions.Load(),
+		DroppedFrames: h.metrics.droppedFrames.Load(),
+	}
+	for client := range h.clients {
+		depth := len(client.send)
+		stats.QueuedFrames += depth
+		stats.MaxQueueDepth = max(stats.MaxQueueDepth, depth)
+	}
+	return stats
+}
+
+// IsOnline reports whether userID has an open connection on any node.
+func (h *Hub) IsOnline(ctx context.Context, userID int64) (bool, error) {
+	presences, err := h.presence.Get(ctx, []int64{userID})
+	if err != nil {
+		return false, err
+	}
+	return presences[0].Online, nil
+}
+
+// Presence returns the presence of every user in userIDs, in the same order.
+func (h *Hub) Presence(ctx context.Context, userIDs []int64) ([]Presence, error) {
+	return h.presence.Get(ctx, userIDs)
+}
+
+// writeToLocalClients queues message for every connection of receiverID on
+// this node but except, which may be nil.
+func (h *Hub) writeToLocalClients(receiverID int64, message SequencedFrame, except *Client) bool {
+	h.RLock()
+	clients := make([]*Client, 0, len(h.clientsWithIDKey[receiverID]))
+	for client := range h.clientsWithIDKey[receiverID] {
+		if client != except {
+			clients = append(clients, client)
+		}
+	}
+	h.RUnlock()
+
+	for _, client := range clients {
+		client.enqueue(message)
+	}
+	return len(clients) > 0
+}
+
+// deliverRemote hands a frame published by another node to the local clients
+// it is addressed to.
+func (h *Hub) deliverRemote(message *BackplaneMessage) {
+	if message.UserID == 0 {
+		h.broadcastLocal(message.Frame)
+		return
+	}
+	h.writeToLocalClients(message.UserID, SequencedFrame{Seq: message.Seq, Frame: message.Frame}, nil)
+}
@@ -0,0 +1,27 @@
+package ws
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBroadcastAfterRunReturned(t *testing.T) {
+	hub := NewHub(NewDispatcher(), NewLocalBackplane(), NewMemoryPresenceStore(), NewMemoryEventLog())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	hub.Run(ctx)
+
+	done := make(chan struct{})
+	go func() {
+		hub.Broadcast(&Event{Type: EVENT_PRESENCE})
+		hub.deliverRemote(&BackplaneMessage{Frame: []byte(`{}`)})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("broadcasting blocked after Run returned")
+	}
+}
@@ -0,0 +1,339 @@
+package ws
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// msgpackCase is a MessagePack value and the JSON it decodes to.
+type msgpackCase struct {
+	name string
+	data []byte
+	json string
+}
+
+// msgpackCases covers every format byte msgpackToJSON accepts.
+var msgpackCases = []msgpackCase{
+	{"positive fixint 0", []byte{0x00}, `0`},
+	{"positive fixint 127", []byte{0x7f}, `127`},
+	{"fixmap empty", []byte{0x80}, `{}`},
+	{"fixmap", []byte{0x81, 0xa1, 'a', 0x01}, `{"a":1}`},
+	{"fixmap 15", append([]byte{0x8f}, fixmapEntries(15)...), fixmapJSON(15)},
+	{"fixarray empty", []byte{0x90}, `[]`},
+	{"fixarray", []byte{0x92, 0x01, 0xc0}, `[1,null]`},
+	{"fixarray 15", append([]byte{0x9f}, bytes.Repeat([]byte{0x01}, 15)...), "[" + strings.TrimSuffix(strings.Repeat("1,", 15), ",") + "]"},
+	{"fixstr empty", []byte{0xa0}, `""`},
+	{"fixstr 31", append([]byte{0xbf}, strings.Repeat("x", 31)...), `"` + strings.Repeat("x", 31) + `"`},
+	{"nil", []byte{0xc0}, `null`},
+	{"false", []byte{0xc2}, `false`},
+	{"true", []byte{0xc3}, `true`},
+	{"bin 8", []byte{0xc4, 0x02, 0xde, 0xad}, `"3q0="`},
+	{"bin 16", []byte{0xc5, 0x00, 0x01, 0xff}, `"/w=="`},
+	{"bin 32", []byte{0xc6, 0x00, 0x00, 0x00, 0x00}, `""`},
+	{"float 32", []byte{0xca, 0x3f, 0xc0, 0x00, 0x00}, `1.5`},
+	{"float 64", []byte{0xcb, 0x40, 0x09, 0x21, 0xf9, 0xf0, 0x1b, 0x86, 0x6e}, `3.14159`},
+	{"uint 8", []byte{0xcc, 0xff}, `255`},
+	{"uint 16", []byte{0xcd, 0xff, 0xff}, `65535`},
+	{"uint 32", []byte{0xce, 0xff, 0xff, 0xff, 0xff}, `4294967295`},
+	{"uint 64", []byte{0xcf, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, `18446744073709551615`},
+	{"int 8", []byte{0xd0, 0x80}, `-128`},
+	{"int 16", []byte{0xd1, 0x80, 0x00}, `-32768`},
+	{"int 32", []byte{0xd2, 0x80, 0x00, 0x00, 0x00}, `-2147483648`},
+	{"int 64", []byte{0xd3, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, `-9223372036854775808`},
+	{"str 8", []byte{0xd9, 0x02, 'h', 'i'}, `"hi"`},
+	{"str 16", []byte{0xda, 0x00, 0x02, 'h', 'i'}, `"hi"`},
+	{"str 32", []byte{0xdb, 0x00, 0x00, 0x00, 0x02, 'h', 'i'}, `"hi"`},
+	{"array 16", []byte{0xdc, 0x00, 0x02, 0xc2, 0xc3}, `[false,true]`},
+	{"array 32", []byte{0xdd, 0x00, 0x00, 0x00, 0x01, 0xa0}, `[""]`},
+	{"map 16", []byte{0xde, 0x00, 0x01, 0xa1, 'k', 0xc0}, `{"k":null}`},
+	{"map 32", []byte{0xdf, 0x00, 0x00, 0x00, 0x01, 0xa1, 'k', 0x90}, `{"k":[]}`},
+	{"negative fixint -32", []byte{0xe0}, `-32`},
+	{"negative fixint -1", []byte{0xff}, `-1`},
+	{"nested", []byte{0x82, 0xa4, 't', 'y', 'p', 'e', 0xa3, 'A', 'C', 'K', 0xa4, 'd', 'a', 't', 'a', 0x91, 0x81, 0xa2, 'i', 'd', 0xcd, 0x01, 0x00}, `{"data":[{"id":256}],"type":"ACK"}`},
+}
+
+func fixmapEntries(n int) []byte {
+	var entries []byte
+	for i := range n {
+		entries = append(entries, 0xa1, 'a'+byte(i), byte(i))
+	}
+	return entries
+}
+
+func fixmapJSON(n int) string {
+	object := make(map[string]int, n)
+	for i := range n {
+		object[string(rune('a'+i))] = i
+	}
+	b, _ := json.Marshal(object)
+	return string(b)
+}
+
+func TestMsgpackToJSON(t *testing.T) {
+	for _, tc := range msgpackCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := msgpackToJSON(tc.data)
+			if err != nil {
+				t.Fatalf("msgpackToJSON(% x) returned error: %v", tc.data, err)
+			}
+			if string(got) != tc.json {
+				t.Errorf("msgpackToJSON(% x) = %s, want %s", tc.data, got, tc.json)
+			}
+		})
+	}
+}
+
+func TestMsgpackToJSONUnsupportedFormats(t *testing.T) {
+	// Never used, ext 8/16/32 and fixext 1/2/4/8/16.
+	for _, b := range []byte{0xc1, 0xc7, 0xc8, 0xc9, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8} {
+		data := append([]byte{b}, bytes.Repeat([]byte{0x01}, 17)...)
+		if _, err := msgpackToJSON(data); err == nil || errors.Is(err, errMsgpackTruncated) {
+			t.Errorf("msgpackToJSON(%#02x ...) error = %v, want unsupported format", b, err)
+		}
+	}
+}
+
+// TestMsgpackToJSONEveryFormat makes sure no format byte is left unhandled:
+// each one is either decoded by a case above or rejected as unsupported.
+func TestMsgpackToJSONEveryFormat(t *testing.T) {
+	covered := make(map[byte]bool)
+	for _, tc := range msgpackCases {
+		covered[tc.data[0]] = true
+	}
+	for _, b := range []byte{0xc1, 0xc7, 0xc8, 0xc9, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8} {
+		covered[b] = true
+	}
+
+	for b := 0; b <= 0xff; b++ {
+		fixed := b <= 0x7f || b >= 0xe0 || (b >= 0x80 && b <= 0xbf)
+		if !fixed && !covered[byte(b)] {
+			t.Errorf("format %#02x has no test case", b)
+		}
+		// Every byte must either decode or fail cleanly.
+		msgpackToJSON([]byte{byte(b)})
+	}
+}
+
+func TestMsgpackToJSONTruncated(t *testing.T) {
+	for _, tc := range msgpackCases {
+		for n := range len(tc.data) {
+			if _, err := msgpackToJSON(tc.data[:n]); err == nil {
+				t.Errorf("%s: msgpackToJSON(% x) succeeded, want error", tc.name, tc.data[:n])
+			}
+		}
+	}
+}
+
+func TestMsgpackToJSONLengthBeyondData(t *testing.T) {
+	for _, data := range [][]byte{
+		{0xdb, 0xff, 0xff, 0xff, 0xff, 'x'},
+		{0xc6, 0xff, 0xff, 0xff, 0xff, 'x'},
+		{0xdd, 0xff, 0xff, 0xff, 0xff, 0xc0},
+		{0xdf, 0xff, 0xff, 0xff, 0xff, 0xa0, 0xc0},
+	} {
+		if _, err := msgpackToJSON(data); !errors.Is(err, errMsgpackTruncated) {
+			t.Errorf("msgpackToJSON(% x) error = %v, want %v", data, err, errMsgpackTruncated)
+		}
+	}
+}
+
+func TestMsgpackToJSONTrailingData(t *testing.T) {
+	if _, err := msgpackToJSON([]byte{0xc0, 0xc0}); err == nil {
+		t.Error("msgpackToJSON accepted trailing data")
+	}
+}
+
+func TestMsgpackToJSONNonStringKey(t *testing.T) {
+	if _, err := msgpackToJSON([]byte{0x81, 0x01, 0x01}); err == nil {
+		t.Error("msgpackToJSON accepted a map with an int key")
+	}
+}
+
+func nestedArrays(depth int) []byte {
+	return append(bytes.Repeat([]byte{0x91}, depth), 0xc0)
+}
+
+func TestMsgpackToJSONDepthLimit(t *testing.T) {
+	if _, err := msgpackToJSON(nestedArrays(msgpackMaxDepth)); err != nil {
+		t.Errorf("msgpackToJSON rejected %d levels of nesting: %v", msgpackMaxDepth, err)
+	}
+	if _, err := msgpackToJSON(nestedArrays(msgpackMaxDepth + 1)); err == nil {
+		t.Errorf("msgpackToJSON accepted %d levels of nesting", msgpackMaxDepth+1)
+	}
+
+	nestedMaps := append(bytes.Repeat([]byte{0x81, 0xa1, 'k'}, msgpackMaxDepth+1), 0xc0)
+	if _, err := msgpackToJSON(nestedMaps); err == nil {
+		t.Errorf("msgpackToJSON accepted %d levels of nested maps", msgpackMaxDepth+1)
+	}
+}
+
+func TestJSONToMsgpackFormats(t *testing.T) {
+	tests := []struct {
+		json   string
+		format byte
+	}{
+		{`null`, 0xc0},
+		{`false`, 0xc2},
+		{`true`, 0xc3},
+		{`0`, 0x00},
+		{`127`, 0x7f},
+		{`-1`, 0xff},
+		{`-32`, 0xe0},
+		{`-33`, 0xd0},
+		{`-128`, 0xd0},
+		{`128`, 0xd1},
+		{`-32768`, 0xd1},
+		{`32768`, 0xd2},
+		{`-2147483648`, 0xd2},
+		{`2147483648`, 0xd3},
+		{`-9223372036854775808`, 0xd3},
+		{`18446744073709551615`, 0xcf},
+		{`1.5`, 0xcb},
+		{`""`, 0xa0},
+		{`"` + strings.Repeat("x", 31) + `"`, 0xbf},
+		{`"` + strings.Repeat("x", 32) + `"`, 0xd9},
+		{`"` + strings.Repeat("x", 256) + `"`, 0xda},
+		{`"` + strings.Repeat("x", 65536) + `"`, 0xdb},
+		{`[]`, 0x90},
+		{jsonArray(15), 0x9f},
+		{jsonArray(16), 0xdc},
+		{jsonArray(65536), 0xdd},
+		{`{}`, 0x80},
+		{fixmapJSON(15), 0x8f},
+		{fixmapJSON(16), 0xde},
+	}
+
+	for _, tc := range tests {
+		data, err := jsonToMsgpack([]byte(tc.json))
+		if err != nil {
+			t.Errorf("jsonToMsgpack(%.20s) returned error: %v", tc.json, err)
+			continue
+		}
+		if data[0] != tc.format {
+			t.Errorf("jsonToMsgpack(%.20s) format = %#02x, want %#02x", tc.json, data[0], tc.format)
+		}
+
+		got, err := msgpackToJSON(data)
+		if err != nil {
+			t.Errorf("msgpackToJSON(jsonToMsgpack(%.20s)) returned error: %v", tc.json, err)
+			continue
+		}
+		if string(got) != tc.json {
+			t.Errorf("round trip of %.20s = %.20s", tc.json, got)
+		}
+	}
+}
+
+func TestJSONToMsgpackOutOfRange(t *testing.T) {
+	if _, err := jsonToMsgpack([]byte(`1e400`)); err == nil {
+		t.Error("jsonToMsgpack(1e400) succeeded, want error")
+	}
+}
+
+func TestJSONToMsgpackMap32(t *testing.T) {
+	object := make(map[string]int, 65536)
+	for i := range 65536 {
+		object[strconv.Itoa(i)] = i
+	}
+	frame, _ := json.Marshal(object)
+
+	data, err := jsonToMsgpack(frame)
+	if err != nil {
+		t.Fatalf("jsonToMsgpack returned error: %v", err)
+	}
+	if data[0] != 0xdf {
+		t.Errorf("format = %#02x, want 0xdf", data[0])
+	}
+	got, err := msgpackToJSON(data)
+	if err != nil {
+		t.Fatalf("msgpackToJSON returned error: %v", err)
+	}
+	if !bytes.Equal(got, frame) {
+		t.Error("round trip of a map with 65536 keys changed it")
+	}
+}
+
+func jsonArray(n int) string {
+	return "[" + strings.TrimSuffix(strings.Repeat("1,", n), ",") + "]"
+}
+
+func TestMsgpackRoundTripEvent(t *testing.T) {
+	frame := []byte(`{"data":{"attachments":["a/b.png"],"content":"héllo","id":42,"replyTo":null,"sentAt":"2024-01-02T03:04:05Z"},"seq":7,"type":"MESSAGE"}`)
+
+	data, err := jsonToMsgpack(frame)
+	if err != nil {
+		t.Fatalf("jsonToMsgpack returned error: %v", err)
+	}
+	got, err := msgpackToJSON(data)
+	if err != nil {
+		t.Fatalf("msgpackToJSON returned error: %v", err)
+	}
+	if !bytes.Equal(got, frame) {
+		t.Errorf("round trip = %s, want %s", got, frame)
+	}
+}
+
+func FuzzMsgpackToJSON(f *testing.F) {
+	for _, tc := range msgpackCases {
+		f.Add(tc.data)
+		f.Add(tc.data[:len(tc.data)-1])
+	}
+	f.Add(nestedArrays(msgpackMaxDepth))
+	f.Add(nestedArrays(msgpackMaxDepth + 1))
+	f.Add([]byte{0xdd, 0xff, 0xff, 0xff, 0xff})
+	f.Add([]byte{0xdf, 0x7f, 0xff, 0xff, 0xff, 0xa0})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		frame, err := msgpackToJSON(data)
+		if err != nil {
+			return
+		}
+		if !json.Valid(frame) {
+			t.Fatalf("msgpackToJSON(% x) = %s, not valid JSON", data, frame)
+		}
+		if depth := jsonDepth(frame); depth > msgpackMaxDepth+1 {
+			t.Fatalf("msgpackToJSON(% x) nested %d levels deep", data, depth)
+		}
+
+		// Whatever decodes must survive a trip back through the encoder.
+		encoded, err := jsonToMsgpack(frame)
+		if err != nil {
+			t.Fatalf("jsonToMsgpack(%s) returned error: %v", frame, err)
+		}
+		again, err := msgpackToJSON(encoded)
+		if err != nil {
+			t.Fatalf("msgpackToJSON(jsonToMsgpack(%s)) returned error: %v", frame, err)
+		}
+		var want, got any
+		json.Unmarshal(frame, &want)
+		json.Unmarshal(again, &got)
+		// Compared as values, -0 comes back as 0.
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("round trip of %s = %s", frame, again)
+		}
+	})
+}
+
+// jsonDepth returns how deeply arrays and objects nest in frame.
+func jsonDepth(frame []byte) int {
+	decoder := json.NewDecoder(bytes.NewReader(frame))
+	depth, deepest := 0, 0
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return deepest
+		}
+		switch token {
+		case json.Delim('['), json.Delim('{'):
+			depth++
+			deepest = max(deepest, depth)
+		case json.Delim(']'), json.Delim('}'):
+			depth--
+		}
+	}
+}
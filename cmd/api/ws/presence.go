@@ -0,0 +1,85 @@
+package ws
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Presence is the online state of a user across every node.
+type Presence struct {
+	UserID   int64      `json:"userId"`
+	Online   bool       `json:"online"`
+	LastSeen *time.Time `json:"lastSeen,omitempty"`
+}
+
+// PresenceStore tracks the open connections of every user and when each user
+// was last seen online.
+type PresenceStore interface {
+	// Connect records a new connection and reports whether it is the first
+	// one the user has open.
+	Connect(ctx context.Context, userID int64, connID string) (bool, error)
+	// Disconnect forgets a connection and reports whether it was the last one
+	// the user had open, in which case at is recorded as last seen.
+	Disconnect(ctx context.Context, userID int64, connID string, at time.Time) (bool, error)
+	Get(ctx context.Context, userIDs []int64) ([]Presence, error)
+}
+
+// MemoryPresenceStore keeps presence in process, it is only accurate when a
+// single API instance is running.
+type MemoryPresenceStore struct {
+	mu          sync.RWMutex
+	connections map[int64]map[string]bool
+	lastSeen    map[int64]time.Time
+}
+
+func NewMemoryPresenceStore() *MemoryPresenceStore {
+	return &MemoryPresenceStore{
+		connections: make(map[int64]map[string]bool),
+		lastSeen:    make(map[int64]time.Time),
+	}
+}
+
+func (s *MemoryPresenceStore) Connect(ctx context.Context, userID int64, connID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.connections[userID]; !ok {
+		s.connections[userID] = make(map[string]bool)
+	}
+	s.connections[userID][connID] = true
+	return len(s.connections[userID]) == 1, nil
+}
+
+func (s *MemoryPresenceStore) Disconnect(ctx context.Context, userID int64, connID string, at time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.connections[userID][connID] {
+		return false, nil
+	}
+	delete(s.connections[userID], connID)
+	if len(s.connections[userID]) > 0 {
+		return false, nil
+	}
+	delete(s.connections, userID)
+	s.lastSeen[userID] = at
+	return true, nil
+}
+
+func (s *MemoryPresenceStore) Get(ctx context.Context, userIDs []int64) ([]Presence, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	presences := make([]Presence, len(userIDs))
+	for i, userID := range userIDs {
+		presences[i] = Presence{
+			UserID: userID,
+			Online: len(s.connections[userID]) > 0,
+		}
+		if lastSeen, ok := s.lastSeen[userID]; ok {
+			presences[i].LastSeen = &lastSeen
+		}
+	}
+	return presences, nil
+}
@@ -0,0 +1,99 @@
+package ws
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// Connections of a node that died without unregistering them are
+	// forgotten after this long without any activity for the user.
+	redisConnectionsExpiry = 24 * time.Hour
+	redisLastSeenExpiry    = 30 * 24 * time.Hour
+)
+
+// RedisPresenceStore keeps the connections of every user in a Redis set and
+// their last seen time as a unix timestamp, so every node shares one view.
+type RedisPresenceStore struct {
+	db *redis.Client
+}
+
+func NewRedisPresenceStore(rdb *redis.Client) *RedisPresenceStore {
+	return &RedisPresenceStore{db: rdb}
+}
+
+func (s *RedisPresenceStore) Connect(ctx context.Context, userID int64, connID string) (bool, error) {
+	key := connectionsKey(userID)
+	pipe := s.db.TxPipeline()
+	pipe.SAdd(ctx, key, connID)
+	pipe.Expire(ctx, key, redisConnectionsExpiry)
+	count := pipe.SCard(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, err
+	}
+	return count.Val() == 1, nil
+}
+
+func (s *RedisPresenceStore) Disconnect(ctx context.Context, userID int64, connID string, at time.Time) (bool, error) {
+	key := connectionsKey(userID)
+	pipe := s.db.TxPipeline()
+	removed := pipe.SRem(ctx, key, connID)
+	count := pipe.SCard(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, err
+	}
+	if removed.Val() == 0 || count.Val() > 0 {
+		return false, nil
+	}
+
+	err := s.db.SetEX(ctx, lastSeenKey(userID), at.Unix(), redisLastSeenExpiry).Err()
+	return true, err
+}
+
+func (s *RedisPresenceStore) Get(ctx context.Context, userIDs []int64) ([]Presence, error) {
+	if len(userIDs) == 0 {
+		return []Presence{}, nil
+	}
+
+	pipe := s.db.Pipeline()
+	counts := make([]*redis.IntCmd, len(userIDs))
+	lastSeens := make([]*redis.StringCmd, len(userIDs))
+	for i, userID := range userIDs {
+		counts[i] = pipe.SCard(ctx, connectionsKey(userID))
+		lastSeens[i] = pipe.Get(ctx, lastSeenKey(userID))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	presences := make([]Presence, len(userIDs))
+	for i, userID := range userIDs {
+		presences[i] = Presence{UserID: userID, Online: counts[i].Val() > 0}
+
+		val, err := lastSeens[i].Result()
+		if err == redis.Nil {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		unix, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		lastSeen := time.Unix(unix, 0).UTC()
+		presences[i].LastSeen = &lastSeen
+	}
+	return presences, nil
+}
+
+func connectionsKey(userID int64) string {
+	return fmt.Sprintf("ws:connections:%d", userID)
+}
+
+func lastSeenKey(userID int64) string {
+	return fmt.Sprintf("ws:last_seen:%d", userID)
+}
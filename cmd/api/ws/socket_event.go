@@ -1,11 +1,112 @@
 package ws
 
-import "github.com/9thDuck/chat_go.git/internal/store"
+import "encoding/json"
 
-type MessageEvent struct {
-	Message store.Message `json:"message"`
-	Type    string        `json:"type"`
+// Event is the envelope for every frame the server pushes to a client. Events
+// pushed through the hub carry a per user Seq, replies to a single connection
+// have none.
+type Event struct {
+	Type      string `json:"type"`
+	Seq       int64  `json:"seq,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
+	Data      any    `json:"data,omitempty"`
 }
+
+// ClientEvent is the envelope for every frame a client sends to the server.
+// Data is decoded by the handler registered for Type.
+type ClientEvent struct {
+	Type      string          `json:"type"`
+	RequestID string          `json:"requestId,omitempty"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// ConnectedEventData is sent before any replayed event. ResyncRequired is set
+// when the event log no longer holds everything after the client's lastSeq.
+type ConnectedEventData struct {
+	ConnectionID   string `json:"connectionId"`
+	DeviceID       string `json:"deviceId"`
+	Replayed       int    `json:"replayed"`
+	ResyncRequired bool   `json:"resyncRequired"`
+}
+
+type AcknowledgedEventData struct {
+	MessageIDs []int64 `json:"messageIds"`
+}
+
+type ReadReceiptEventData struct {
+	ReaderID   int64   `json:"readerId"`
+	MessageIDs []int64 `json:"messageIds"`
+}
+
+type TypingEventData struct {
+	UserID int64 `json:"userId"`
+	Typing bool  `json:"typing"`
+}
+
+// ReactionEventData is sent when UserID adds or removes a reaction.
+type ReactionEventData struct {
+	MessageID int64  `json:"messageId"`
+	UserID    int64  `json:"userId"`
+	Emoji     string `json:"emoji"`
+}
+
+// MessageTimerEventData tells a user that UserID changed the disappearing
+// message timer of their conversation, 0 seconds when turned off.
+type MessageTimerEventData struct {
+	UserID  int64 `json:"userId"`
+	Seconds int   `json:"seconds"`
+}
+
+// RoomRemovedEventData tells a user they are no longer in a room, because
+// they left, were removed or the room was deleted.
+type RoomRemovedEventData struct {
+	RoomID int64 `json:"roomId"`
+}
+
+type ErrorEventData struct {
+	Message string `json:"message"`
+}
+
 const (
-	EVENT_MESSAGE                = "MESSAGE"
+	// server -> client
+	EVENT_CONNECTED    = "CONNECTED"
+	EVENT_MESSAGE      = "MESSAGE"
+	EVENT_MESSAGE_SENT = "MESSAGE_SENT"
+	EVENT_ACKNOWLEDGED = "ACKNOWLEDGED"
+	EVENT_PRESENCE     = "PRESENCE"
+	EVENT_TYPING       = "TYPING"
+	EVENT_MARKED_READ  = "MARKED_READ"
+	EVENT_READ_RECEIPT = "READ_RECEIPT"
+	EVENT_ERROR        = "ERROR"
+
+	EVENT_MESSAGE_EDITED = "MESSAGE_EDITED"
+	EVENT_MESSAGE_UNSENT = "MESSAGE_UNSENT"
+	// EVENT_SCHEDULED_MESSAGE_RELEASED tells the sender of a scheduled message
+	// that it was sent.
+	EVENT_SCHEDULED_MESSAGE_RELEASED = "SCHEDULED_MESSAGE_RELEASED"
+	EVENT_MESSAGE_TIMER_UPDATED      = "MESSAGE_TIMER_UPDATED"
+	// EVENT_MESSAGE_EXPIRED tells the sender of a message that it was deleted
+	// before reaching everyone it was sent to.
+	EVENT_MESSAGE_EXPIRED = "MESSAGE_EXPIRED"
+
+	EVENT_REACTION_ADDED   = "REACTION_ADDED"
+	EVENT_REACTION_REMOVED = "REACTION_REMOVED"
+
+	EVENT_ROOM_UPDATED = "ROOM_UPDATED"
+	EVENT_ROOM_REMOVED = "ROOM_REMOVED"
+	// EVENT_ROOM_MEMBER_UPDATED carries a member whose role or mute changed.
+	EVENT_ROOM_MEMBER_UPDATED = "ROOM_MEMBER_UPDATED"
+
+	EVENT_CONTACT_REQUEST_RECEIVED  = "CONTACT_REQUEST_RECEIVED"
+	EVENT_CONTACT_REQUEST_ACCEPTED  = "CONTACT_REQUEST_ACCEPTED"
+	EVENT_CONTACT_REQUEST_REJECTED  = "CONTACT_REQUEST_REJECTED"
+	EVENT_CONTACT_REQUEST_WITHDRAWN = "CONTACT_REQUEST_WITHDRAWN"
+	EVENT_CONTACT_REMOVED           = "CONTACT_REMOVED"
+
+	// client -> server
+	EVENT_SEND_MESSAGE = "SEND_MESSAGE"
+	EVENT_ACK          = "ACK"
+	EVENT_TYPING_START = "TYPING_START"
+	EVENT_TYPING_STOP  = "TYPING_STOP"
+	EVENT_MARK_READ    = "MARK_READ"
 )
@@ -0,0 +1,121 @@
+package ws
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// sseTransport writes frames as Server-Sent Events, the seq of a frame becomes
+// its event id so EventSource reconnects with it in Last-Event-ID.
+type sseTransport struct {
+	w  http.ResponseWriter
+	rc *http.ResponseController
+}
+
+func (t sseTransport) writeFrame(frame SequencedFrame) error {
+	t.rc.SetWriteDeadline(time.Now().Add(writeWait))
+	if frame.Seq != 0 {
+		if _, err := fmt.Fprintf(t.w, "id: %d\n", frame.Seq); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(t.w, "data: %s\n\n", frame.Frame); err != nil {
+		return err
+	}
+	return t.rc.Flush()
+}
+
+// writePing sends a comment, it keeps proxies from timing the stream out.
+func (t sseTransport) writePing() error {
+	t.rc.SetWriteDeadline(time.Now().Add(writeWait))
+	if _, err := fmt.Fprint(t.w, ": ping\n\n"); err != nil {
+		return err
+	}
+	return t.rc.Flush()
+}
+
+// writeClose has nothing to send, the stream simply ends and EventSource
+// reconnects on its own.
+func (t sseTransport) writeClose(code int, reason string) error {
+	return nil
+}
+
+func (t sseTransport) Close() error {
+	return nil
+}
+
+// ServeSSE streams the events of userID as Server-Sent Events for clients that
+// cannot open a WebSocket. The stream is registered with hub like a socket and
+// receives the same envelopes, frames are sent as the data of unnamed events.
+// It is write only, clients use the REST API to send. A reconnecting client
+// resumes from Last-Event-ID, or the lastSeq query param on its first request.
+// ServeSSE blocks until the stream ends.
+func ServeSSE(w http.ResponseWriter, r *http.Request, hub *Hub, userID int64) {
+	deviceID := r.URL.Query().Get("deviceId")
+	if deviceID != "" && !deviceIDPattern.MatchString(deviceID) {
+		http.Error(w, "deviceId must be 1 to 64 letters, digits, '-' or '_'", http.StatusBadRequest)
+		return
+	}
+
+	lastSeq, err := parseLastSeq(r.URL.Query().Get("lastSeq"))
+	if lastEventID := r.Header.Get("Last-Event-ID"); lastEventID != "" {
+		lastSeq, err = parseLastSeq(lastEventID)
+	}
+	if err != nil {
+		http.Error(w, "Last-Event-ID and lastSeq must be a non negative integer", http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := w.(http.Flusher); !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	connID, err := newConnectionID()
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to open event stream", http.StatusInternalServerError)
+		return
+	}
+	if deviceID == "" {
+		deviceID = connID
+	}
+
+	if !hub.acquire() {
+		http.Error(w, shutdownCloseReason, http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// Stop nginx and the like from buffering the stream.
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	client := newClient(hub, sseTransport{w: w, rc: rc}, userID, connID, deviceID)
+	hub.registerClient(client)
+	client.resume(r.Context(), lastSeq)
+
+	// The request context is the only way to learn the peer went away, it
+	// plays the part of the WebSocket read pump.
+	go func() {
+		select {
+		case <-r.Context().Done():
+		case <-client.done:
+		}
+		hub.unregisterClient(client)
+		hub.connections.Done()
+	}()
+
+	// The response writer may only be used until ServeSSE returns, so the
+	// write pump runs right here.
+	client.writeMessages()
+}
+
+// isStream reports whether c is an SSE stream rather than a WebSocket.
+func (c *Client) isStream() bool {
+	_, ok := c.conn.(sseTransport)
+	return ok
+}
@@ -0,0 +1,121 @@
+package ws
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// TicketTTL is how long a ticket may wait to be redeemed.
+const TicketTTL = 10 * time.Second
+
+var ErrInvalidTicket = errors.New("ticket is invalid, expired or already used")
+
+// TicketStore issues single use tickets that authenticate a WebSocket upgrade
+// for clients that cannot send the auth cookies with it.
+type TicketStore interface {
+	Issue(ctx context.Context, userID int64) (string, error)
+	// Redeem returns the user a ticket was issued to and invalidates it, it
+	// returns ErrInvalidTicket when there is nothing to redeem.
+	Redeem(ctx context.Context, ticket string) (int64, error)
+}
+
+func newTicket() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// MemoryTicketStore keeps tickets in process, it only works when a single API
+// instance is running.
+type MemoryTicketStore struct {
+	mu      sync.Mutex
+	tickets map[string]memoryTicket
+}
+
+type memoryTicket struct {
+	userID    int64
+	expiresAt time.Time
+}
+
+func NewMemoryTicketStore() *MemoryTicketStore {
+	return &MemoryTicketStore{tickets: make(map[string]memoryTicket)}
+}
+
+func (s *MemoryTicketStore) Issue(ctx context.Context, userID int64) (string, error) {
+	ticket, err := newTicket()
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for t, issued := range s.tickets {
+		if now.After(issued.expiresAt) {
+			delete(s.tickets, t)
+		}
+	}
+	s.tickets[ticket] = memoryTicket{userID: userID, expiresAt: now.Add(TicketTTL)}
+	return ticket, nil
+}
+
+func (s *MemoryTicketStore) Redeem(ctx context.Context, ticket string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	issued, ok := s.tickets[ticket]
+	if !ok {
+		return 0, ErrInvalidTicket
+	}
+	delete(s.tickets, ticket)
+	if time.Now().After(issued.expiresAt) {
+		return 0, ErrInvalidTicket
+	}
+	return issued.userID, nil
+}
+
+// RedisTicketStore keeps tickets in Redis so a ticket issued by one node can
+// be redeemed on any other.
+type RedisTicketStore struct {
+	db *redis.Client
+}
+
+func NewRedisTicketStore(rdb *redis.Client) *RedisTicketStore {
+	return &RedisTicketStore{db: rdb}
+}
+
+func (s *RedisTicketStore) Issue(ctx context.Context, userID int64) (string, error) {
+	ticket, err := newTicket()
+	if err != nil {
+		return "", err
+	}
+	if err := s.db.SetEX(ctx, ticketKey(ticket), userID, TicketTTL).Err(); err != nil {
+		return "", err
+	}
+	return ticket, nil
+}
+
+func (s *RedisTicketStore) Redeem(ctx context.Context, ticket string) (int64, error) {
+	val, err := s.db.GetDel(ctx, ticketKey(ticket)).Result()
+	if err == redis.Nil {
+		return 0, ErrInvalidTicket
+	} else if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(val, 10, 64)
+}
+
+func ticketKey(ticket string) string {
+	return fmt.Sprintf("ws:ticket:%s", ticket)
+}
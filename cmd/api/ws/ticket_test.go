@@ -0,0 +1,85 @@
+package ws
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryTicketStoreRedeem(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryTicketStore()
+
+	first, err := s.Issue(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.Issue(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second {
+		t.Fatal("Issue returned the same ticket twice")
+	}
+
+	tests := []struct {
+		name    string
+		ticket  string
+		want    int64
+		wantErr error
+	}{
+		{"first ticket", first, 1, nil},
+		{"first ticket again", first, 0, ErrInvalidTicket},
+		{"second ticket", second, 2, nil},
+		{"second ticket again", second, 0, ErrInvalidTicket},
+		{"unknown ticket", "unknown", 0, ErrInvalidTicket},
+		{"empty ticket", "", 0, ErrInvalidTicket},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.Redeem(ctx, tt.ticket)
+			if err != tt.wantErr || got != tt.want {
+				t.Errorf("Redeem() = %d, %v, want %d, %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMemoryTicketStoreExpiry(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryTicketStore()
+
+	expired, err := s.Issue(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.tickets[expired] = memoryTicket{userID: 1, expiresAt: time.Now().Add(-time.Second)}
+
+	if _, err := s.Redeem(ctx, expired); err != ErrInvalidTicket {
+		t.Errorf("Redeem() of an expired ticket = %v, want ErrInvalidTicket", err)
+	}
+	if _, ok := s.tickets[expired]; ok {
+		t.Error("Redeem() kept an expired ticket")
+	}
+}
+
+func TestMemoryTicketStoreIssueDropsExpired(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryTicketStore()
+
+	expired, err := s.Issue(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.tickets[expired] = memoryTicket{userID: 1, expiresAt: time.Now().Add(-time.Second)}
+
+	if _, err := s.Issue(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.tickets[expired]; ok {
+		t.Error("Issue() kept an expired ticket")
+	}
+	if len(s.tickets) != 1 {
+		t.Errorf("store holds %d tickets, want 1", len(s.tickets))
+	}
+}
@@ -1,8 +1,14 @@
 package ws
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -18,30 +24,129 @@ const (
 	// Send pings to peer with this period. Must be less than pongWait.
 	pingPeriod = (pongWait * 9) / 10
 
-	// Maximum message size allowed from peer.
-	maxMessageSize = 512
+	// Maximum message size allowed from peer. Large enough for a SEND_MESSAGE
+	// event carrying max length content and attachments.
+	maxMessageSize = 16 * 1024
+
+	// Frames that may be queued for a client before it is considered too slow
+	// and disconnected.
+	sendQueueSize = 256
 )
 
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
+	Subprotocols:    subprotocols(),
+	CheckOrigin:     checkOrigin,
+}
+
+// allowedOrigins is set once at startup by AllowOrigins.
+var allowedOrigins = map[string]bool{}
+
+// AllowOrigins sets the origins browsers may open a socket from. Without any,
+// only pages served from the API's own host may.
+func AllowOrigins(origins []string) {
+	allowedOrigins = make(map[string]bool, len(origins))
+	for _, origin := range origins {
+		allowedOrigins[strings.ToLower(strings.TrimSuffix(origin, "/"))] = true
+	}
+}
+
+// checkOrigin lets requests without an Origin through, native clients do not
+// send one and are authenticated by their ticket instead.
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
 		return true
-	},
+	}
+	if len(allowedOrigins) > 0 {
+		return allowedOrigins[strings.ToLower(origin)]
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
 }
 
+var deviceIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// IsValidDeviceID reports whether id can identify a device.
+func IsValidDeviceID(id string) bool {
+	return deviceIDPattern.MatchString(id)
+}
+
+// Serve upgrades the request and registers the connection with hub. Clients
+// may pass a stable deviceId query param to identify the device across
+// reconnects, otherwise the connection id doubles as the device id. A client
+// reconnecting passes the seq of the last event it saw as lastSeq and gets
+// every event after it replayed before live traffic. Events are JSON text
+// frames unless the client negotiates another encoding through the
+// Sec-WebSocket-Protocol header, see Codec.
 func Serve(w http.ResponseWriter, r *http.Request, hub *Hub, userID int64) {
+	deviceID := r.URL.Query().Get("deviceId")
+	if deviceID != "" && !deviceIDPattern.MatchString(deviceID) {
+		http.Error(w, "deviceId must be 1 to 64 letters, digits, '-' or '_'", http.StatusBadRequest)
+		return
+	}
+
+	lastSeq, err := parseLastSeq(r.URL.Query().Get("lastSeq"))
+	if err != nil {
+		http.Error(w, "lastSeq must be a non negative integer", http.StatusBadRequest)
+		return
+	}
+
+	connID, err := newConnectionID()
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to upgrade to WebSocket", http.StatusInternalServerError)
+		return
+	}
+	if deviceID == "" {
+		deviceID = connID
+	}
+
+	if !hub.acquire() {
+		http.Error(w, shutdownCloseReason, http.StatusServiceUnavailable)
+		return
+	}
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
+		hub.release()
 		fmt.Println(err)
 		http.Error(w, "Failed to upgrade to WebSocket", http.StatusInternalServerError)
 		return
 	}
-	client := &Client{hub: hub, conn: conn, send: make(chan []byte), id: userID}
-	client.hub.register <- client
+	transport := wsTransport{Conn: conn, codec: codecFor(conn.Subprotocol())}
+	client := newClient(hub, transport, userID, connID, deviceID)
+	hub.registerClient(client)
+	client.resume(r.Context(), lastSeq)
 
 	// Allow collection of memory referenced by the caller by doing all work in
 	// new goroutines.
 	go client.writeMessages()
-	go client.readMessages()
+	go client.readMessages(transport)
+}
+
+// parseLastSeq returns -1 when the client did not pass a lastSeq.
+func parseLastSeq(value string) (int64, error) {
+	if value == "" {
+		return -1, nil
+	}
+	lastSeq, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if lastSeq < 0 {
+		return 0, fmt.Errorf("negative lastSeq %d", lastSeq)
+	}
+	return lastSeq, nil
+}
+
+func newConnectionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
 }
@@ -0,0 +1,2 @@
+ALTER TABLE users
+DROP COLUMN read_receipts_enabled;
\ No newline at end of file
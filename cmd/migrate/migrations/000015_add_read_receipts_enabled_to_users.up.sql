@@ -0,0 +1,2 @@
+ALTER TABLE users
+ADD COLUMN read_receipts_enabled BOOLEAN NOT NULL DEFAULT TRUE;
\ No newline at end of file
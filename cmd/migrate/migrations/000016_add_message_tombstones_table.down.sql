@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_message_tombstones_receiver_id;
+DROP INDEX IF EXISTS idx_message_tombstones_sender_id;
+DROP TABLE IF EXISTS message_tombstones;
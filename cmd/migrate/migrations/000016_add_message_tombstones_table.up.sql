@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS message_tombstones (
+    id BIGSERIAL PRIMARY KEY,
+    message_id BIGINT UNIQUE NOT NULL,
+    sender_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    receiver_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    deleted_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_message_tombstones_sender_id ON message_tombstones (sender_id, id);
+CREATE INDEX idx_message_tombstones_receiver_id ON message_tombstones (receiver_id, id);
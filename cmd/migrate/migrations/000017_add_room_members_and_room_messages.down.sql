@@ -0,0 +1,20 @@
+DROP INDEX IF EXISTS idx_message_tombstones_room_id;
+DELETE FROM message_tombstones WHERE room_id IS NOT NULL;
+ALTER TABLE message_tombstones DROP COLUMN IF EXISTS room_id;
+ALTER TABLE message_tombstones ALTER COLUMN receiver_id SET NOT NULL;
+
+DROP INDEX IF EXISTS idx_room_message_deliveries_user_id;
+DROP TABLE IF EXISTS room_message_deliveries;
+
+DELETE FROM message_versions WHERE message_id IN (SELECT id FROM messages WHERE room_id IS NOT NULL);
+DELETE FROM attachments WHERE message_id IN (SELECT id FROM messages WHERE room_id IS NOT NULL);
+DELETE FROM messages WHERE room_id IS NOT NULL;
+DROP INDEX IF EXISTS idx_messages_room_id;
+ALTER TABLE messages DROP CONSTRAINT IF EXISTS messages_receiver_or_room;
+ALTER TABLE messages DROP COLUMN IF EXISTS room_id;
+ALTER TABLE messages ALTER COLUMN receiver_id SET NOT NULL;
+
+DROP INDEX IF EXISTS idx_room_members_user_id;
+DROP TABLE IF EXISTS room_members;
+
+ALTER TABLE rooms DROP COLUMN IF EXISTS owner_id;
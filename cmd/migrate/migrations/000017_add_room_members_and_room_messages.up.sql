@@ -0,0 +1,40 @@
+ALTER TABLE rooms
+ADD COLUMN owner_id BIGINT REFERENCES users(id) ON DELETE SET NULL;
+
+CREATE TABLE IF NOT EXISTS room_members (
+    room_id BIGINT NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    joined_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (room_id, user_id)
+);
+
+CREATE INDEX idx_room_members_user_id ON room_members (user_id);
+
+-- A message goes either to a user or to a room.
+ALTER TABLE messages
+ADD COLUMN room_id BIGINT REFERENCES rooms(id);
+
+ALTER TABLE messages ALTER COLUMN receiver_id DROP NOT NULL;
+ALTER TABLE messages ALTER COLUMN receiver_id DROP DEFAULT;
+
+ALTER TABLE messages
+ADD CONSTRAINT messages_receiver_or_room CHECK ((receiver_id IS NULL) <> (room_id IS NULL));
+
+CREATE INDEX idx_messages_room_id ON messages (room_id);
+
+-- Members a room message is still pending for, a row is removed once the
+-- member acknowledges the message. The message is purged with the last row.
+CREATE TABLE IF NOT EXISTS room_message_deliveries (
+    message_id BIGINT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    PRIMARY KEY (message_id, user_id)
+);
+
+CREATE INDEX idx_room_message_deliveries_user_id ON room_message_deliveries (user_id);
+
+ALTER TABLE message_tombstones ALTER COLUMN receiver_id DROP NOT NULL;
+
+ALTER TABLE message_tombstones
+ADD COLUMN room_id BIGINT REFERENCES rooms(id) ON DELETE CASCADE;
+
+CREATE INDEX idx_message_tombstones_room_id ON message_tombstones (room_id, id);
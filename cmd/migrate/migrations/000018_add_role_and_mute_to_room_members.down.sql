@@ -0,0 +1,2 @@
+ALTER TABLE room_members DROP COLUMN IF EXISTS muted_until;
+ALTER TABLE room_members DROP COLUMN IF EXISTS role;
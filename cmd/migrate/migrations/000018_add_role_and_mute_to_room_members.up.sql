@@ -0,0 +1,10 @@
+ALTER TABLE room_members
+ADD COLUMN role VARCHAR(16) NOT NULL DEFAULT 'member' CHECK (role IN ('owner', 'moderator', 'member'));
+
+ALTER TABLE room_members
+ADD COLUMN muted_until timestamp(0) with time zone;
+
+UPDATE room_members m
+SET role = 'owner'
+FROM rooms r
+WHERE r.id = m.room_id AND r.owner_id = m.user_id;
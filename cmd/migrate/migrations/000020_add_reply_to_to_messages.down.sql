@@ -0,0 +1,2 @@
+ALTER TABLE messages DROP COLUMN IF EXISTS reply_to;
+ALTER TABLE messages DROP COLUMN IF EXISTS reply_to_id;
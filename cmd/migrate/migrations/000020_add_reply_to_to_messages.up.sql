@@ -0,0 +1,8 @@
+-- reply_to_id has no foreign key, the message replied to may be unsent, expire
+-- or, in a room, be purged once every member got it. reply_to keeps what is
+-- needed to render the quote regardless.
+ALTER TABLE messages
+ADD COLUMN reply_to_id BIGINT;
+
+ALTER TABLE messages
+ADD COLUMN reply_to JSONB;
@@ -0,0 +1,2 @@
+-- Serves the last message and unread count lookups of the conversation list.
+CREATE INDEX idx_messages_conversation ON messages (sender_id, receiver_id, created_at DESC, id DESC);
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_messages_send_at;
+DELETE FROM message_versions WHERE message_id IN (SELECT id FROM messages WHERE send_at IS NOT NULL);
+DELETE FROM reactions WHERE message_id IN (SELECT id FROM messages WHERE send_at IS NOT NULL);
+DELETE FROM attachments WHERE message_id IN (SELECT id FROM messages WHERE send_at IS NOT NULL);
+DELETE FROM messages WHERE send_at IS NOT NULL;
+ALTER TABLE messages DROP COLUMN IF EXISTS send_at;
@@ -0,0 +1,6 @@
+-- A message with send_at set is scheduled, it stays hidden from its
+-- recipients until the dispatcher releases it and clears send_at.
+ALTER TABLE messages
+ADD COLUMN send_at timestamp(0) with time zone;
+
+CREATE INDEX idx_messages_send_at ON messages (send_at) WHERE send_at IS NOT NULL;
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_messages_reply_to_id;
+DROP INDEX IF EXISTS idx_messages_undelivered;
+DROP INDEX IF EXISTS idx_messages_expires_at;
+ALTER TABLE messages DROP COLUMN IF EXISTS expires_at;
+ALTER TABLE rooms DROP COLUMN IF EXISTS message_ttl_seconds;
+ALTER TABLE contacts DROP COLUMN IF EXISTS message_ttl_seconds;
@@ -0,0 +1,15 @@
+-- Disappearing message timers, 0 turns the timer off.
+ALTER TABLE contacts
+ADD COLUMN message_ttl_seconds INTEGER NOT NULL DEFAULT 0 CHECK (message_ttl_seconds >= 0);
+
+ALTER TABLE rooms
+ADD COLUMN message_ttl_seconds INTEGER NOT NULL DEFAULT 0 CHECK (message_ttl_seconds >= 0);
+
+ALTER TABLE messages
+ADD COLUMN expires_at timestamp(0) with time zone;
+
+CREATE INDEX idx_messages_expires_at ON messages (expires_at) WHERE expires_at IS NOT NULL;
+-- Serves the lookup of undelivered messages past the retention period.
+CREATE INDEX idx_messages_undelivered ON messages (created_at) WHERE is_delivered = false AND room_id IS NULL;
+-- Serves clearing the reply snapshots of purged messages.
+CREATE INDEX idx_messages_reply_to_id ON messages (reply_to_id) WHERE reply_to_id IS NOT NULL;
@@ -0,0 +1,5 @@
+-- Lets clients retry sending a message without creating it twice.
+ALTER TABLE messages
+ADD COLUMN client_message_id VARCHAR(64);
+
+CREATE UNIQUE INDEX idx_messages_sender_client_message_id ON messages (sender_id, client_message_id) WHERE client_message_id IS NOT NULL;
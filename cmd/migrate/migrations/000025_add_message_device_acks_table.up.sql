@@ -0,0 +1,11 @@
+-- Every device of a recipient that acknowledged a message. The message stays
+-- pending for the recipient's other devices until they acknowledge it too.
+CREATE TABLE IF NOT EXISTS message_device_acks (
+    message_id BIGINT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    device_id VARCHAR(64) NOT NULL,
+    acked_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (message_id, user_id, device_id)
+);
+
+CREATE INDEX idx_message_device_acks_user_device ON message_device_acks (user_id, device_id);
@@ -0,0 +1,9 @@
+-- Every device a user connected or acknowledged messages from with a device id
+-- of its own. A message is delivered to a user once each of their devices seen
+-- lately acknowledged it.
+CREATE TABLE IF NOT EXISTS user_devices (
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    device_id VARCHAR(64) NOT NULL,
+    last_seen_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, device_id)
+);
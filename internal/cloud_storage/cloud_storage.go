@@ -8,6 +8,11 @@ type PreSigner interface {
 	Create(ctx context.Context, bucketName, key string, lifetimeInSeconds int64) (string, error)
 	Get(ctx context.Context, bucketName string, objectKey string, lifetimeSecs int64) (string, error)
 }
+type ObjectRemover interface {
+	Delete(ctx context.Context, bucketName string, objectKeys []string) error
+}
+
 type CloudStorage struct {
 	PreSigner PreSigner
+	Objects   ObjectRemover
 }
@@ -2,10 +2,12 @@ package cloudStorage
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
 
 type Presigner struct {
@@ -40,6 +42,37 @@ func (presigner Presigner) Create(
 	return request.URL, nil
 }
 
+// S3ObjectRemover deletes objects in batches of at most s3DeleteBatchSize keys,
+// the most a single DeleteObjects call takes.
+type S3ObjectRemover struct {
+	Client *s3.Client
+}
+
+const s3DeleteBatchSize = 1000
+
+func (r S3ObjectRemover) Delete(ctx context.Context, bucketName string, objectKeys []string) error {
+	for start := 0; start < len(objectKeys); start += s3DeleteBatchSize {
+		batch := objectKeys[start:min(start+s3DeleteBatchSize, len(objectKeys))]
+		objects := make([]types.ObjectIdentifier, len(batch))
+		for i, key := range batch {
+			objects[i] = types.ObjectIdentifier{Key: aws.String(key)}
+		}
+
+		output, err := r.Client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(bucketName),
+			Delete: &types.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return err
+		}
+		if len(output.Errors) > 0 {
+			failed := output.Errors[0]
+			return fmt.Errorf("failed to delete %d objects, first %s: %s", len(output.Errors), aws.ToString(failed.Key), aws.ToString(failed.Message))
+		}
+	}
+	return nil
+}
+
 func NewS3Presigner(s3PresignClient *s3.PresignClient) *Presigner {
 	return &Presigner{
 		Presigner: s3PresignClient,
@@ -75,5 +108,6 @@ func NewS3CloudStorage(cfg *aws.Config) *CloudStorage {
 	s3Presigner := NewS3Presigner(s3PresignerClient)
 	return &CloudStorage{
 		PreSigner: s3Presigner,
+		Objects:   S3ObjectRemover{Client: s3Client},
 	}
 }
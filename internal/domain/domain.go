@@ -1,18 +1,29 @@
 package domain
 
 type User struct {
-	ID             int64  `json:"id"`
-	Username       string `json:"username"`
-	Email          string `json:"email"`
-	HashedPassword string `json:"-"`
-	PublicKey      string `json:"publicKey"`
-	FirstName      string `json:"firstName"`
-	LastName       string `json:"lastName"`
-	ProfilePic     string `json:"profilePic"`
-	RoleID         int64  `json:"roleId"`
-	Role           *Role  `json:"role"`
-	CreatedAt      string `json:"createdAt"`
-	UpdatedAt      string `json:"updatedAt"`
+	ID                  int64  `json:"id"`
+	Username            string `json:"username"`
+	Email               string `json:"email"`
+	HashedPassword      string `json:"-"`
+	PublicKey           string `json:"publicKey"`
+	FirstName           string `json:"firstName"`
+	LastName            string `json:"lastName"`
+	ProfilePic          string `json:"profilePic"`
+	RoleID              int64  `json:"roleId"`
+	Role                *Role  `json:"role"`
+	ReadReceiptsEnabled bool   `json:"readReceiptsEnabled"`
+	CreatedAt           string `json:"createdAt"`
+	UpdatedAt           string `json:"updatedAt"`
+}
+
+// PublicProfile is what any contact may see of a user.
+type PublicProfile struct {
+	ID         int64  `json:"id"`
+	Username   string `json:"username"`
+	PublicKey  string `json:"publicKey"`
+	FirstName  string `json:"firstName"`
+	LastName   string `json:"lastName"`
+	ProfilePic string `json:"profilePic"`
 }
 
 type Role struct {
@@ -25,15 +36,85 @@ type Role struct {
 type Message struct {
 	ID          int64     `json:"id"`
 	SenderID    int64     `json:"senderId"`
-	ReceiverID  int64     `json:"receiverId"`
+	ReceiverID  int64     `json:"receiverId,omitempty"`
+	RoomID      *int64    `json:"roomId,omitempty"`
 	Content     string    `json:"content"`
 	Attachments *[]string `json:"attachments"`
 	IsRead      bool      `json:"isRead"`
 	IsDelivered bool      `json:"isDelivered"`
 	Version     int64     `json:"version"`
 	Edited      bool      `json:"edited"`
-	CreatedAt   string    `json:"createdAt"`
-	UpdatedAt   string    `json:"updatedAt"`
+	ReplyToID   *int64    `json:"replyToId,omitempty"`
+	// ClientMessageID is the id the sender's client gave the message, unique
+	// per sender.
+	ClientMessageID *string `json:"clientMessageId,omitempty"`
+	// SendAt is set while the message is scheduled.
+	SendAt *string `json:"sendAt,omitempty"`
+	// ExpiresAt is when a disappearing message gets deleted.
+	ExpiresAt *string `json:"expiresAt,omitempty"`
+	// ReplyTo is a snapshot of the message replied to taken when the reply
+	// was sent, it outlives the original unless the original expires.
+	ReplyTo *MessageReference `json:"replyTo,omitempty"`
+	// Reactions is only loaded for message listings.
+	Reactions []ReactionCount `json:"reactions,omitempty"`
+	CreatedAt string          `json:"createdAt"`
+	UpdatedAt string          `json:"updatedAt"`
+}
+
+// Conversation summarises the direct messages between a user and one of
+// their contacts. LastActivityAt falls back to when they became contacts.
+type Conversation struct {
+	Peer          PublicProfile `json:"peer"`
+	LastMessageID *int64        `json:"lastMessageId"`
+	LastMessageAt *string       `json:"lastMessageAt"`
+	UnreadCount   int           `json:"unreadCount"`
+	// MessageTTLSeconds is the disappearing message timer, 0 when off.
+	MessageTTLSeconds int    `json:"messageTtlSeconds"`
+	LastActivityAt    string `json:"lastActivityAt"`
+}
+
+type MessageReference struct {
+	ID        int64  `json:"id"`
+	SenderID  int64  `json:"senderId"`
+	Content   string `json:"content"`
+	CreatedAt string `json:"createdAt"`
+}
+
+type Reaction struct {
+	ID        int64  `json:"id"`
+	MessageID int64  `json:"messageId"`
+	UserID    int64  `json:"userId"`
+	Emoji     string `json:"emoji"`
+	CreatedAt string `json:"createdAt"`
+}
+
+// ReactionCount is how many users reacted to a message with an emoji.
+type ReactionCount struct {
+	Emoji string `json:"emoji"`
+	Count int    `json:"count"`
+}
+
+type Room struct {
+	ID        int64   `json:"id"`
+	Name      string  `json:"name"`
+	OwnerID   *int64  `json:"ownerId"`
+	MemberIDs []int64 `json:"memberIds"`
+	// ModeratorIDs are the members given the moderator role, the owner is
+	// not listed.
+	ModeratorIDs []int64 `json:"moderatorIds"`
+	// MessageTTLSeconds is the disappearing message timer, 0 when off.
+	MessageTTLSeconds int    `json:"messageTtlSeconds"`
+	CreatedAt         string `json:"createdAt"`
+	UpdatedAt         string `json:"updatedAt"`
+}
+
+type RoomMember struct {
+	RoomID     int64   `json:"roomId"`
+	UserID     int64   `json:"userId"`
+	Role       string  `json:"role"`
+	MutedUntil *string `json:"mutedUntil"`
+	IsMuted    bool    `json:"isMuted"`
+	JoinedAt   string  `json:"joinedAt"`
 }
 
 type Contact struct {
@@ -5,6 +5,7 @@ import (
 	"log/slog"
 	"os"
 	"strconv"
+	"strings"
 )
 
 func GetEnvString(key, fallback string) string {
@@ -36,3 +37,18 @@ func GetBool(key string, fallback bool) bool {
 	}
 	return fallback
 }
+
+// GetEnvList splits a comma separated value, ignoring blank items.
+func GetEnvList(key string, fallback []string) []string {
+	val := os.Getenv(key)
+	if val == "" {
+		return fallback
+	}
+	list := []string{}
+	for _, item := range strings.Split(val, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
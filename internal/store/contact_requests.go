@@ -14,7 +14,29 @@ type ContactRequestsStore struct {
 
 type ContactRequest domain.ContactRequest
 
-func (s *ContactRequestsStore) Create(ctx context.Context, senderID, receiverID int64) error {
+// contactRequestReturning makes a statement on contact_requests return the
+// row in the shape of ContactRequest, minus the message.
+const contactRequestReturning = `
+	RETURNING sender_id, receiver_id, created_at,
+	(SELECT username FROM users WHERE id = sender_id),
+	(SELECT username FROM users WHERE id = receiver_id)`
+
+func scanContactRequest(row *sql.Row) (*ContactRequest, error) {
+	contactRequest := &ContactRequest{}
+	err := row.Scan(
+		&contactRequest.SenderID,
+		&contactRequest.ReceiverID,
+		&contactRequest.CreatedAt,
+		&contactRequest.SenderUsername,
+		&contactRequest.ReceiverUsername,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return contactRequest, nil
+}
+
+func (s *ContactRequestsStore) Create(ctx context.Context, senderID, receiverID int64) (*ContactRequest, error) {
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
 	defer cancel()
 
@@ -34,58 +56,64 @@ func (s *ContactRequestsStore) Create(ctx context.Context, senderID, receiverID
 	INSERT INTO contact_requests (sender_id, receiver_id, status)
 	SELECT $1, $2, 'pending'
 	WHERE NOT EXISTS (SELECT 1 FROM existing_contacts)
-	AND NOT EXISTS (SELECT 1 FROM existing_requests WHERE status != 'rejected')
-	RETURNING sender_id;`
+	AND NOT EXISTS (SELECT 1 FROM existing_requests WHERE status != 'rejected')` + contactRequestReturning
 
-	var returnedSenderID int64
-	err := s.db.QueryRowContext(ctx, query, senderID, receiverID).Scan(&returnedSenderID)
+	contactRequest, err := scanContactRequest(s.db.QueryRowContext(ctx, query, senderID, receiverID))
 
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return ErrContactRequestAlreadyExists
+			return nil, ErrContactRequestAlreadyExists
 		}
 		if pqErr, ok := err.(*pq.Error); ok {
 			switch pqErr.Code {
 			case PQ_CODE_UNIQUE_CONSTRAINT_VIOLATION:
-				return ErrContactRequestAlreadyExists
+				return nil, ErrContactRequestAlreadyExists
 			case PQ_CODE_FOREIGN_KEY_CONSTRAINT_VIOLATION:
-				return ErrContactRequestForeignKeyViolation
+				return nil, ErrContactRequestForeignKeyViolation
 			default:
-				return err
+				return nil, err
 			}
 		}
-		return err
+		return nil, err
 	}
 
-	return nil
+	return contactRequest, nil
 }
 
-func (s *ContactRequestsStore) Get(ctx context.Context, senderID int64, pagination *Pagination) (*[]ContactRequest, int, error) {
+// Get returns a page of the pending contact requests userID sent or received.
+// Pages are keyed on when the request was made and the other user's id.
+func (s *ContactRequestsStore) Get(ctx context.Context, userID int64, pagination *Pagination) (*[]ContactRequest, *CursorPage, error) {
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
 	defer cancel()
 
-	query := `
-		SELECT c.sender_id, c.receiver_id, c.created_at, u.username AS sender_username, 
-		u2.username AS receiver_username, m.content, COUNT(*) OVER() AS total
+	otherUserID := `(CASE WHEN c.sender_id = $1 THEN c.receiver_id ELSE c.sender_id END)`
+	condition, orderBy, keysetArgs := pagination.keyset("c.created_at", otherUserID, pagination.SortDirection, 2)
+
+	pending := `
 		FROM contact_requests c 
 		JOIN users u ON c.sender_id = u.id
 		JOIN users u2 ON c.receiver_id = u2.id
 		JOIN messages m ON m.sender_id = c.sender_id 
 		  AND m.receiver_id = c.receiver_id
 		  AND m.created_at BETWEEN c.created_at - interval '1 second' AND c.created_at + interval '1 second'
-		WHERE (c.sender_id = $1 OR c.receiver_id = $1) AND c.status = 'pending'
-		ORDER BY ` + pagination.Sort + ` ` + pagination.SortDirection + `
-		LIMIT $2 OFFSET $3`
+		WHERE (c.sender_id = $1 OR c.receiver_id = $1) AND c.status = 'pending'`
 
-	rows, err := s.db.QueryContext(ctx, query, senderID, pagination.Limit, pagination.CalculateOffset())
+	query := `
+		SELECT c.sender_id, c.receiver_id, c.created_at, u.username AS sender_username, 
+		u2.username AS receiver_username, m.content` + pending + `
+		AND ` + condition + `
+		ORDER BY ` + orderBy + `
+		LIMIT $2`
+
+	args := append([]any{userID, pagination.keysetLimit()}, keysetArgs...)
+	rows, err := s.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, 0, err
+		return nil, nil, err
 	}
 
 	defer rows.Close()
 
-	contactRequests := make([]ContactRequest, 0, pagination.Limit)
-	total := 0
+	contactRequests := make([]ContactRequest, 0, pagination.keysetLimit())
 
 	for rows.Next() {
 		contactRequest := ContactRequest{}
@@ -96,46 +124,64 @@ func (s *ContactRequestsStore) Get(ctx context.Context, senderID int64, paginati
 			&contactRequest.SenderUsername,
 			&contactRequest.ReceiverUsername,
 			&contactRequest.MessageContent,
-			&total,
 		)
 		if err != nil {
-			return nil, 0, err
+			return nil, nil, err
 		}
 		contactRequests = append(contactRequests, contactRequest)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	contactRequests, page, err := cursorPage(pagination, contactRequests, func(c ContactRequest) (Cursor, error) {
+		otherID := c.SenderID
+		if otherID == userID {
+			otherID = c.ReceiverID
+		}
+		return NewCursor(c.CreatedAt, otherID)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if pagination.WithTotal {
+		total := 0
+		countQuery := `SELECT COUNT(*)` + pending
+		if err := s.db.QueryRowContext(ctx, countQuery, userID).Scan(&total); err != nil {
+			return nil, nil, err
+		}
+		page.Total = &total
+	}
 
-	return &contactRequests, total, nil
+	return &contactRequests, page, nil
 }
 
-func acceptContactRequest(ctx context.Context, tx *sql.Tx, senderID, receiverID int64) error {
+func acceptContactRequest(ctx context.Context, tx *sql.Tx, senderID, receiverID int64) (*ContactRequest, error) {
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
 	defer cancel()
 
 	query := `
 		UPDATE contact_requests
 		SET status = $3
-		WHERE sender_id = $1 AND receiver_id = $2`
+		WHERE sender_id = $1 AND receiver_id = $2` + contactRequestReturning
 
-	res, err := tx.ExecContext(ctx, query, senderID, receiverID, "accepted")
+	contactRequest, err := scanContactRequest(tx.QueryRowContext(ctx, query, senderID, receiverID, "accepted"))
 	if err != nil {
-		return err
-	}
-
-	rowsAffected, err := res.RowsAffected()
-	if err != nil {
-		return err
-	}
-
-	if rowsAffected == 0 {
-		return ErrContactRequestNotFound
+		if err == sql.ErrNoRows {
+			return nil, ErrContactRequestNotFound
+		}
+		return nil, err
 	}
 
-	return nil
+	return contactRequest, nil
 }
 
-func (s *ContactRequestsStore) Accept(ctx context.Context, senderID, receiverID int64) error {
-	return withTx(ctx, s.db, func(tx *sql.Tx) error {
-		err := acceptContactRequest(ctx, tx, senderID, receiverID)
+func (s *ContactRequestsStore) Accept(ctx context.Context, senderID, receiverID int64) (*ContactRequest, error) {
+	var contactRequest *ContactRequest
+	err := withTx(ctx, s.db, func(tx *sql.Tx) error {
+		var err error
+		contactRequest, err = acceptContactRequest(ctx, tx, senderID, receiverID)
 		if err != nil {
 			return err
 		}
@@ -147,53 +193,50 @@ func (s *ContactRequestsStore) Accept(ctx context.Context, senderID, receiverID
 
 		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	return contactRequest, nil
 }
 
-func (s *ContactRequestsStore) Reject(ctx context.Context, senderID, receiverID int64) error {
+func (s *ContactRequestsStore) Reject(ctx context.Context, senderID, receiverID int64) (*ContactRequest, error) {
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
 	defer cancel()
 
 	query := `
 		UPDATE contact_requests
 		SET status = $3
-		WHERE sender_id = $1 AND receiver_id = $2`
+		WHERE sender_id = $1 AND receiver_id = $2` + contactRequestReturning
 
-	res, err := s.db.ExecContext(ctx, query, senderID, receiverID, "rejected")
+	contactRequest, err := scanContactRequest(s.db.QueryRowContext(ctx, query, senderID, receiverID, "rejected"))
 	if err != nil {
-		return err
-	}
-
-	rowsAffected, err := res.RowsAffected()
-	if err != nil {
-		return err
-	}
-
-	if rowsAffected == 0 {
-		return ErrContactRequestNotFound
+		if err == sql.ErrNoRows {
+			return nil, ErrContactRequestNotFound
+		}
+		return nil, err
 	}
 
-	return nil
+	return contactRequest, nil
 }
 
-func (s *ContactRequestsStore) Delete(ctx context.Context, senderID, receiverID int64) error {
+func (s *ContactRequestsStore) Delete(ctx context.Context, senderID, receiverID int64) (*ContactRequest, error) {
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
 	defer cancel()
 
 	query := `
 		DELETE FROM contact_requests
-		WHERE sender_id = $1 AND receiver_id = $2 AND status = 'pending'
-		RETURNING sender_id;`
+		WHERE sender_id = $1 AND receiver_id = $2 AND status = 'pending'` + contactRequestReturning
 
-	var returnedSenderID int64
-	err := s.db.QueryRowContext(ctx, query, senderID, receiverID).Scan(&returnedSenderID)
+	contactRequest, err := scanContactRequest(s.db.QueryRowContext(ctx, query, senderID, receiverID))
 	if err != nil {
 		switch err {
 		case sql.ErrNoRows:
-			return ErrContactRequestNotFound
+			return nil, ErrContactRequestNotFound
 		default:
-			return err
+			return nil, err
 		}
 	}
 
-	return nil
+	return contactRequest, nil
 }
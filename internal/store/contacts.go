@@ -3,6 +3,7 @@ package store
 import (
 	"context"
 	"database/sql"
+	"time"
 
 	"github.com/lib/pq"
 )
@@ -11,40 +12,126 @@ type ContactsStore struct {
 	db *sql.DB
 }
 
-func (s *ContactsStore) Get(ctx context.Context, userID int64, pagination *Pagination) (*[]int64, int, error) {
+// contactsOf selects the id of each contact of the user $1 along with when
+// they became contacts and the disappearing message timer of their
+// conversation.
+const contactsOf = `
+		SELECT
+			CASE
+				WHEN user_id = $1 THEN contact_id
+				ELSE user_id
+			END AS id,
+			created_at,
+			message_ttl_seconds
+		FROM contacts
+		WHERE user_id = $1 OR contact_id = $1`
+
+// Get returns a page of the contacts of userID ordered by when they became
+// contacts.
+func (s *ContactsStore) Get(ctx context.Context, userID int64, pagination *Pagination) (*[]int64, *CursorPage, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	condition, orderBy, keysetArgs := pagination.keyset("c.created_at", "c.id", pagination.SortDirection, 2)
+	query := `
+		WITH c AS (` + contactsOf + `)
+		SELECT c.id, c.created_at
+		FROM c
+		WHERE ` + condition + `
+		ORDER BY ` + orderBy + `
+		LIMIT $2`
+
+	args := append([]any{userID, pagination.keysetLimit()}, keysetArgs...)
+	contactIDs, page, err := queryContactIDsPage(ctx, s.db, pagination, query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if pagination.WithTotal {
+		total := 0
+		countQuery := `SELECT COUNT(*) FROM contacts WHERE user_id = $1 OR contact_id = $1`
+		if err := s.db.QueryRowContext(ctx, countQuery, userID).Scan(&total); err != nil {
+			return nil, nil, err
+		}
+		page.Total = &total
+	}
+
+	return &contactIDs, page, nil
+}
+
+type contactRow struct {
+	id        int64
+	createdAt string
+}
+
+func (c contactRow) cursor() (Cursor, error) {
+	return NewCursor(c.createdAt, c.id)
+}
+
+// queryContactIDsPage runs a keyset query selecting contact ids and when they
+// became contacts, and returns the ids with the cursors around them.
+func queryContactIDsPage(ctx context.Context, db *sql.DB, pagination *Pagination, query string, args ...any) ([]int64, *CursorPage, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	contacts := make([]contactRow, 0, pagination.keysetLimit())
+	for rows.Next() {
+		contact := contactRow{}
+		if err := rows.Scan(&contact.id, &contact.createdAt); err != nil {
+			return nil, nil, err
+		}
+		contacts = append(contacts, contact)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	contacts, page, err := cursorPage(pagination, contacts, contactRow.cursor)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	contactIDs := make([]int64, len(contacts))
+	for i, contact := range contacts {
+		contactIDs[i] = contact.id
+	}
+	return contactIDs, page, nil
+}
+
+// GetAllIDs returns the ids of every contact of userID, unpaginated.
+func (s *ContactsStore) GetAllIDs(ctx context.Context, userID int64) ([]int64, error) {
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
 	defer cancel()
+
 	query := `
 		SELECT (
 			CASE 
 				WHEN user_id = $1 THEN contact_id
 				ELSE user_id
 			END
-		), COUNT(*) OVER() AS total
+		)
 		FROM contacts
-		WHERE user_id = $1 OR contact_id = $1
-		LIMIT $2 OFFSET $3`
+		WHERE user_id = $1 OR contact_id = $1`
 
-	rows, err := s.db.QueryContext(ctx, query, userID, pagination.Limit, pagination.CalculateOffset())
+	rows, err := s.db.QueryContext(ctx, query, userID)
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
-
 	defer rows.Close()
 
-	contactIDSlice := make([]int64, 0, pagination.Limit)
-	total := 0
-
+	contactIDs := []int64{}
 	for rows.Next() {
 		var contactID int64
-		err := rows.Scan(&contactID, &total)
-		if err != nil {
-			return nil, 0, err
+		if err := rows.Scan(&contactID); err != nil {
+			return nil, err
 		}
-		contactIDSlice = append(contactIDSlice, contactID)
+		contactIDs = append(contactIDs, contactID)
 	}
 
-	return &contactIDSlice, total, nil
+	return contactIDs, rows.Err()
 }
 
 func (s *ContactsStore) GetContactExists(ctx context.Context, userID, contactID int64) (bool, error) {
@@ -96,6 +183,33 @@ func (s *ContactsStore) Delete(ctx context.Context, userID, contactID int64) err
 	})
 }
 
+// SetMessageTTL sets the disappearing message timer of the conversation
+// between userID and contactID, 0 turns it off. Messages already sent keep
+// their timer.
+func (s *ContactsStore) SetMessageTTL(ctx context.Context, userID, contactID int64, ttl time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	query := `
+		UPDATE contacts
+		SET message_ttl_seconds = $3, updated_at = NOW()
+		WHERE (user_id = $1 AND contact_id = $2) OR (user_id = $2 AND contact_id = $1)`
+
+	res, err := s.db.ExecContext(ctx, query, userID, contactID, int(ttl.Seconds()))
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrContactNotFound
+	}
+	return nil
+}
+
 func createContact(ctx context.Context, tx *sql.Tx, userID, contactID int64) error {
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
 	defer cancel()
@@ -117,73 +231,39 @@ func createContact(ctx context.Context, tx *sql.Tx, userID, contactID int64) err
 	return nil
 }
 
-func (s *ContactsStore) Search(ctx context.Context, userID int64, searchTerm string, pagination *Pagination) (*[]int64, int, error) {
+// Search returns a page of the contacts of userID whose username or name
+// matches searchTerm, ordered like Get.
+func (s *ContactsStore) Search(ctx context.Context, userID int64, searchTerm string, pagination *Pagination) (*[]int64, *CursorPage, error) {
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
 	defer cancel()
 
-	var sortField string
-	switch pagination.Sort {
-	case "username":
-		sortField = "u.username"
-	case "first_name":
-		sortField = "u.first_name"
-	case "last_name":
-		sortField = "u.last_name"
-	default:
-		sortField = "u.username"
-	}
+	matches := `
+		WITH c AS (` + contactsOf + `)
+		SELECT c.id, c.created_at
+		FROM c JOIN users u ON u.id = c.id
+		WHERE (u.username ILIKE $2 OR u.first_name ILIKE $2 OR u.last_name ILIKE $2)`
 
-	query := `
-		WITH c_ids AS (
-			SELECT 
-				CASE 
-					WHEN user_id = $1 THEN contact_id
-					ELSE user_id
-				END as id
-			FROM contacts
-			WHERE user_id = $1 OR contact_id = $1
-		)
-		SELECT u.id, COUNT(*) OVER() as total
-		FROM users u JOIN c_ids ci ON u.id = ci.id
-		WHERE u.username ILIKE $2 OR u.first_name ILIKE $2 OR u.last_name ILIKE $2
-		ORDER BY ` + sortField + ` ` + pagination.SortDirection + `
-		LIMIT $3 OFFSET $4
-	`
-
-	// query := `
-	// 	SELECT
-	// 		CASE
-	// 			WHEN c.user_id = $1 THEN c.contact_id
-	// 			ELSE c.user_id
-	// 		END as contact_id,
-	// 		COUNT(*) OVER() as total
-	// 	FROM contacts c
-	// 	JOIN users u ON u.id = CASE
-	// 		WHEN c.user_id = $1 THEN c.contact_id
-	// 		ELSE c.user_id
-	// 	END
-	// 	WHERE (c.user_id = $1 OR c.contact_id = $1)
-	// 		AND (u.username ILIKE $2 OR u.first_name ILIKE $2 OR u.last_name ILIKE $2)
-	// 	ORDER BY ` + sortField + ` ` + pagination.SortDirection + `
-	// 	LIMIT $3 OFFSET $4`
+	condition, orderBy, keysetArgs := pagination.keyset("c.created_at", "c.id", pagination.SortDirection, 3)
+	query := matches + `
+		AND ` + condition + `
+		ORDER BY ` + orderBy + `
+		LIMIT $3`
 
 	searchPattern := "%" + searchTerm + "%"
-	rows, err := s.db.QueryContext(ctx, query, userID, searchPattern, pagination.Limit, pagination.CalculateOffset())
+	args := append([]any{userID, searchPattern, pagination.keysetLimit()}, keysetArgs...)
+	contactIDs, page, err := queryContactIDsPage(ctx, s.db, pagination, query, args...)
 	if err != nil {
-		return nil, 0, err
+		return nil, nil, err
 	}
-	defer rows.Close()
-
-	contactsIDSlice := make([]int64, 0, pagination.Limit)
-	total := 0
 
-	for rows.Next() {
-		var contactID int64
-		err := rows.Scan(&contactID, &total)
-		if err != nil {
-			return nil, 0, err
+	if pagination.WithTotal {
+		total := 0
+		countQuery := `SELECT COUNT(*) FROM (` + matches + `) m`
+		if err := s.db.QueryRowContext(ctx, countQuery, userID, searchPattern).Scan(&total); err != nil {
+			return nil, nil, err
 		}
-		contactsIDSlice = append(contactsIDSlice, contactID)
+		page.Total = &total
 	}
-	return &contactsIDSlice, total, nil
+
+	return &contactIDs, page, nil
 }
@@ -0,0 +1,111 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/9thDuck/chat_go.git/internal/domain"
+)
+
+type Conversation domain.Conversation
+
+type ConversationsStore struct {
+	db *sql.DB
+}
+
+// Get returns a page of conversations, one per contact of userID, most
+// recently active first. UnreadCount counts the messages the contact sent that
+// userID has not read yet, delivered or not.
+func (s *ConversationsStore) Get(ctx context.Context, userID int64, pagination *Pagination) (*[]Conversation, *CursorPage, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	condition, orderBy, keysetArgs := pagination.keyset("a.last_activity_at", "a.id", "DESC", 2)
+	query := `
+	WITH c AS (` + contactsOf + `),
+	a AS (
+		SELECT
+			c.id,
+			c.message_ttl_seconds,
+			lm.id AS last_message_id,
+			lm.created_at AS last_message_at,
+			COALESCE(lm.created_at, c.created_at) AS last_activity_at
+		FROM c
+		LEFT JOIN LATERAL (
+			SELECT m.id, m.created_at
+			FROM messages m
+			WHERE ((m.sender_id = $1 AND m.receiver_id = c.id) OR (m.sender_id = c.id AND m.receiver_id = $1))
+			AND m.send_at IS NULL
+			ORDER BY m.created_at DESC, m.id DESC
+			LIMIT 1
+		) lm ON true
+	)
+	SELECT
+		u.id, u.username, u.public_key, u.first_name, u.last_name, u.profile_pic,
+		a.last_message_id, a.last_message_at,
+		unread.count,
+		a.message_ttl_seconds,
+		a.last_activity_at
+	FROM a
+	JOIN users u ON u.id = a.id
+	CROSS JOIN LATERAL (
+		SELECT COUNT(*) AS count
+		FROM messages m
+		WHERE m.sender_id = a.id AND m.receiver_id = $1 AND m.is_read = false AND m.send_at IS NULL
+	) unread
+	WHERE ` + condition + `
+	ORDER BY ` + orderBy + `
+	LIMIT $2`
+
+	args := append([]any{userID, pagination.keysetLimit()}, keysetArgs...)
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	conversations := make([]Conversation, 0, pagination.keysetLimit())
+
+	for rows.Next() {
+		conversation := Conversation{}
+		err := rows.Scan(
+			&conversation.Peer.ID,
+			&conversation.Peer.Username,
+			&conversation.Peer.PublicKey,
+			&conversation.Peer.FirstName,
+			&conversation.Peer.LastName,
+			&conversation.Peer.ProfilePic,
+			&conversation.LastMessageID,
+			&conversation.LastMessageAt,
+			&conversation.UnreadCount,
+			&conversation.MessageTTLSeconds,
+			&conversation.LastActivityAt,
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+		conversations = append(conversations, conversation)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	conversations, page, err := cursorPage(pagination, conversations, func(c Conversation) (Cursor, error) {
+		return NewCursor(c.LastActivityAt, c.Peer.ID)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if pagination.WithTotal {
+		total := 0
+		countQuery := `SELECT COUNT(*) FROM (` + contactsOf + `) c`
+		if err := s.db.QueryRowContext(ctx, countQuery, userID).Scan(&total); err != nil {
+			return nil, nil, err
+		}
+		page.Total = &total
+	}
+
+	return &conversations, page, nil
+}
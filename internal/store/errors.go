@@ -28,6 +28,20 @@ const (
 	// contacts
 	DefaultContactAlreadyExistsErrMsg = "contact already exists"
 	DefaultContactNotFoundErrMsg      = "contact not found"
+
+	// messages
+	DefaultUnsendWindowExpiredErrMsg   = "message can no longer be unsent"
+	DefaultInvalidReplyToErrMsg        = "the message replied to is not part of this conversation"
+	DefaultMessageAlreadyExistsErrMsg  = "a message with this client message id was already sent"
+	DefaultClientMessageIDReusedErrMsg = "this client message id was already used for a different message"
+
+	// rooms
+	DefaultRoomMemberAlreadyExistsErrMsg = "user is already a member of the room"
+	DefaultRoomMemberNotFoundErrMsg      = "user is not a member of the room"
+
+	// reactions
+	DefaultReactionAlreadyExistsErrMsg = "you already reacted with this emoji"
+	DefaultReactionNotFoundErrMsg      = "reaction not found"
 )
 
 var (
@@ -53,6 +67,20 @@ var (
 	// contacts
 	ErrContactAlreadyExists = errors.New(DefaultContactAlreadyExistsErrMsg)
 	ErrContactNotFound      = errors.New(DefaultContactNotFoundErrMsg)
+
+	// messages
+	ErrUnsendWindowExpired   = errors.New(DefaultUnsendWindowExpiredErrMsg)
+	ErrInvalidReplyTo        = errors.New(DefaultInvalidReplyToErrMsg)
+	ErrMessageAlreadyExists  = errors.New(DefaultMessageAlreadyExistsErrMsg)
+	ErrClientMessageIDReused = errors.New(DefaultClientMessageIDReusedErrMsg)
+
+	// rooms
+	ErrRoomMemberAlreadyExists = errors.New(DefaultRoomMemberAlreadyExistsErrMsg)
+	ErrRoomMemberNotFound      = errors.New(DefaultRoomMemberNotFoundErrMsg)
+
+	// reactions
+	ErrReactionAlreadyExists = errors.New(DefaultReactionAlreadyExistsErrMsg)
+	ErrReactionNotFound      = errors.New(DefaultReactionNotFoundErrMsg)
 )
 
 const (
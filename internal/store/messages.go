@@ -3,7 +3,13 @@ package store
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/9thDuck/chat_go.git/internal/domain"
 	"github.com/lib/pq"
@@ -19,143 +25,1415 @@ type MessageVersion struct {
 	CreatedAt string `json:"created_at"`
 }
 
+// MessageTombstone records that a message was unsent, so devices that still
+// hold a copy learn to drop it.
+type MessageTombstone struct {
+	ID         int64  `json:"id"`
+	MessageID  int64  `json:"messageId"`
+	SenderID   int64  `json:"senderId"`
+	ReceiverID int64  `json:"receiverId,omitempty"`
+	RoomID     *int64 `json:"roomId,omitempty"`
+	DeletedAt  string `json:"deletedAt"`
+}
+
+// ExpiredMessage is a message deleted because its disappearing timer ran out
+// or because it went undelivered for longer than the retention period.
+type ExpiredMessage struct {
+	MessageID  int64  `json:"messageId"`
+	SenderID   int64  `json:"senderId"`
+	ReceiverID int64  `json:"receiverId,omitempty"`
+	RoomID     *int64 `json:"roomId,omitempty"`
+	// Undelivered is set when the receiver, or a member of the room, never
+	// got the message.
+	Undelivered bool `json:"-"`
+}
+
 type MessagesStore struct {
 	db *sql.DB
 }
 
-func (s *MessagesStore) Get(ctx context.Context, userID int64, pagination *Pagination) (*[]Message, int, error) {
+const messageColumns = `
+	id,
+	sender_id,
+	COALESCE(receiver_id, 0),
+	room_id,
+	content,
+	is_read,
+	is_delivered,
+	version,
+	edited,
+	reply_to_id,
+	reply_to,
+	expires_at,
+	created_at,
+	updated_at`
+
+func scanMessage(row interface{ Scan(...any) error }, message *Message, extra ...any) error {
+	dest := []any{
+		&message.ID,
+		&message.SenderID,
+		&message.ReceiverID,
+		&message.RoomID,
+		&message.Content,
+		&message.IsRead,
+		&message.IsDelivered,
+		&message.Version,
+		&message.Edited,
+		&message.ReplyToID,
+		replySnapshot{&message.ReplyTo},
+		&message.ExpiresAt,
+		&message.CreatedAt,
+		&message.UpdatedAt,
+	}
+	return row.Scan(append(dest, extra...)...)
+}
+
+// Get returns a page of the messages still pending for userID, oldest first.
+func (s *MessagesStore) Get(ctx context.Context, userID int64, pagination *Pagination) (*[]Message, *CursorPage, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	pending := `
+	((sender_id = $1 OR receiver_id = $1) AND is_delivered = false AND send_at IS NULL)
+	OR id IN (SELECT message_id FROM room_message_deliveries WHERE user_id = $1)`
+
+	condition, orderBy, keysetArgs := pagination.keyset("created_at", "id", "ASC", 2)
+	query := `
+	SELECT ` + messageColumns + `
+	FROM messages
+	WHERE (` + pending + `)
+	AND ` + condition + `
+	ORDER BY ` + orderBy + `
+	LIMIT $2`
+
+	args := append([]any{userID, pagination.keysetLimit()}, keysetArgs...)
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	messages := make([]Message, 0, pagination.keysetLimit())
+
+	for rows.Next() {
+		message := Message{}
+		err := scanMessage(rows, &message)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		emptyAttachments := []string{}
+		message.Attachments = &emptyAttachments
+
+		messages = append(messages, message)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	messages, page, err := cursorPage(pagination, messages, func(m Message) (Cursor, error) {
+		return NewCursor(m.CreatedAt, m.ID)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := loadAttachments(ctx, s.db, messages); err != nil {
+		return nil, nil, err
+	}
+
+	if pagination.WithTotal {
+		total := 0
+		countQuery := `SELECT COUNT(*) FROM messages WHERE ` + pending
+		if err := s.db.QueryRowContext(ctx, countQuery, userID).Scan(&total); err != nil {
+			return nil, nil, err
+		}
+		page.Total = &total
+	}
+
+	return &messages, page, nil
+}
+
+// GetByRoom returns a page of the history of a room, oldest first.
+func (s *MessagesStore) GetByRoom(ctx context.Context, roomID int64, pagination *Pagination) (*[]Message, *CursorPage, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	condition, orderBy, keysetArgs := pagination.keyset("created_at", "id", "ASC", 2)
+	query := `
+	SELECT ` + messageColumns + `
+	FROM messages
+	WHERE room_id = $1 AND send_at IS NULL
+	AND ` + condition + `
+	ORDER BY ` + orderBy + `
+	LIMIT $2`
+
+	args := append([]any{roomID, pagination.keysetLimit()}, keysetArgs...)
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	messages := make([]Message, 0, pagination.keysetLimit())
+
+	for rows.Next() {
+		message := Message{}
+		err := scanMessage(rows, &message)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		emptyAttachments := []string{}
+		message.Attachments = &emptyAttachments
+
+		messages = append(messages, message)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	messages, page, err := cursorPage(pagination, messages, func(m Message) (Cursor, error) {
+		return NewCursor(m.CreatedAt, m.ID)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := loadAttachments(ctx, s.db, messages); err != nil {
+		return nil, nil, err
+	}
+
+	if pagination.WithTotal {
+		total := 0
+		countQuery := `SELECT COUNT(*) FROM messages WHERE room_id = $1 AND send_at IS NULL`
+		if err := s.db.QueryRowContext(ctx, countQuery, roomID).Scan(&total); err != nil {
+			return nil, nil, err
+		}
+		page.Total = &total
+	}
+
+	return &messages, page, nil
+}
+
+// GetPending returns up to limit messages still pending delivery to
+// receiverID, directly or through a room, with an id greater than afterID,
+// oldest first. Messages deviceID already acknowledged are left out, an empty
+// deviceID leaves none out.
+func (s *MessagesStore) GetPending(ctx context.Context, receiverID int64, deviceID string, afterID int64, limit int) (*[]Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	query := `
+	SELECT ` + messageColumns + `
+	FROM messages m
+	WHERE id > $2 AND (
+		(receiver_id = $1 AND is_delivered = false AND send_at IS NULL)
+		OR id IN (SELECT message_id FROM room_message_deliveries WHERE user_id = $1)
+	)
+	AND NOT EXISTS (
+		SELECT 1 FROM message_device_acks a
+		WHERE a.message_id = m.id AND a.user_id = $1 AND a.device_id = $4
+	)
+	ORDER BY id ASC
+	LIMIT $3`
+
+	rows, err := s.db.QueryContext(ctx, query, receiverID, afterID, limit, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := make([]Message, 0, limit)
+	for rows.Next() {
+		message := Message{}
+		err := scanMessage(rows, &message)
+		if err != nil {
+			return nil, err
+		}
+
+		emptyAttachments := []string{}
+		message.Attachments = &emptyAttachments
+		messages = append(messages, message)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := loadAttachments(ctx, s.db, messages); err != nil {
+		return nil, err
+	}
+
+	return &messages, nil
+}
+
+func (s *MessagesStore) GetByID(ctx context.Context, messageID int64) (*Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	query := `
+	SELECT ` + messageColumns + `
+	FROM messages
+	WHERE id = $1 AND send_at IS NULL`
+
+	message := Message{}
+	err := scanMessage(s.db.QueryRowContext(ctx, query, messageID), &message)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	emptyAttachments := []string{}
+	message.Attachments = &emptyAttachments
+	messages := []Message{message}
+	if err := loadAttachments(ctx, s.db, messages); err != nil {
+		return nil, err
+	}
+
+	return &messages[0], nil
+}
+
+// Create inserts message along with its attachments. When the sender already
+// sent a message with the same client message id, nothing is inserted, message
+// is replaced by the original and ErrMessageAlreadyExists is returned. Should
+// the original differ in receiver, room, reply, content or attachments, the id
+// was reused rather than retried and ErrClientMessageIDReused is returned
+// instead, leaving message as it was.
+func (s *MessagesStore) Create(ctx context.Context, message *Message) error {
+	err := withTx(ctx, s.db, func(tx *sql.Tx) error {
+		if message.ReplyToID != nil {
+			if err := loadReplySnapshot(ctx, tx, message); err != nil {
+				return err
+			}
+		}
+
+		err := addMessage(ctx, tx, message)
+		if err != nil {
+			return err
+		}
+
+		if message.Attachments != nil && len(*message.Attachments) > 0 {
+			err = addAttachments(ctx, tx, message.ID, message.Attachments)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Scheduled room messages become pending for the members on release.
+		if message.RoomID != nil && message.SendAt == nil {
+			err = addRoomMessageDeliveries(ctx, tx, message)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != ErrMessageAlreadyExists {
+		return err
+	}
+
+	original := Message{}
+	if err := s.getByClientMessageID(ctx, message.SenderID, *message.ClientMessageID, &original); err != nil {
+		return err
+	}
+	if !isSameSubmission(message, &original) {
+		return ErrClientMessageIDReused
+	}
+	*message = original
+	return ErrMessageAlreadyExists
+}
+
+// isSameSubmission reports whether message, about to be created, carries what
+// original was created with.
+func isSameSubmission(message, original *Message) bool {
+	if message.ReceiverID != original.ReceiverID || message.Content != original.Content {
+		return false
+	}
+	if !equalIDs(message.RoomID, original.RoomID) || !equalIDs(message.ReplyToID, original.ReplyToID) {
+		return false
+	}
+
+	var attachments, originalAttachments []string
+	if message.Attachments != nil {
+		attachments = slices.Sorted(slices.Values(*message.Attachments))
+	}
+	if original.Attachments != nil {
+		originalAttachments = slices.Sorted(slices.Values(*original.Attachments))
+	}
+	return slices.Equal(attachments, originalAttachments)
+}
+
+func equalIDs(a, b *int64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// getByClientMessageID replaces message with the one senderID sent with
+// clientMessageID, scheduled or not.
+func (s *MessagesStore) getByClientMessageID(ctx context.Context, senderID int64, clientMessageID string, message *Message) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	query := `
+	SELECT ` + messageColumns + `,
+		client_message_id,
+		send_at
+	FROM messages
+	WHERE sender_id = $1 AND client_message_id = $2`
+
+	original := Message{}
+	err := scanMessage(s.db.QueryRowContext(ctx, query, senderID, clientMessageID), &original, &original.ClientMessageID, &original.SendAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	emptyAttachments := []string{}
+	original.Attachments = &emptyAttachments
+	messages := []Message{original}
+	if err := loadAttachments(ctx, s.db, messages); err != nil {
+		return err
+	}
+
+	*message = messages[0]
+	return nil
+}
+
+// loadReplySnapshot sets message.ReplyTo to a snapshot of the message it
+// replies to, which has to belong to the same conversation.
+func loadReplySnapshot(ctx context.Context, tx *sql.Tx, message *Message) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	query := `
+	SELECT id, sender_id, content, created_at
+	FROM messages
+	WHERE id = $1 AND send_at IS NULL AND (
+		($2::bigint IS NOT NULL AND room_id = $2)
+		OR ($2::bigint IS NULL AND (
+			(sender_id = $3 AND receiver_id = $4) OR (sender_id = $4 AND receiver_id = $3)
+		))
+	)`
+
+	reference := domain.MessageReference{}
+	err := tx.QueryRowContext(ctx, query, *message.ReplyToID, message.RoomID, message.SenderID, message.ReceiverID).Scan(
+		&reference.ID,
+		&reference.SenderID,
+		&reference.Content,
+		&reference.CreatedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrInvalidReplyTo
+		}
+		return err
+	}
+
+	message.ReplyTo = &reference
+	return nil
+}
+
+// replySnapshot scans the reply_to column into a message reference, leaving it
+// nil for messages that are not replies.
+type replySnapshot struct {
+	reference **domain.MessageReference
+}
+
+func (s replySnapshot) Scan(src any) error {
+	if src == nil {
+		*s.reference = nil
+		return nil
+	}
+	b, ok := src.([]byte)
+	if !ok {
+		return fmt.Errorf("reply_to: unexpected type %T", src)
+	}
+	return json.Unmarshal(b, s.reference)
+}
+
+// addRoomMessageDeliveries makes a room message pending for every member of
+// the room but its sender.
+func addRoomMessageDeliveries(ctx context.Context, tx *sql.Tx, message *Message) error {
+	query := `
+	INSERT INTO room_message_deliveries (message_id, user_id)
+	SELECT $1, user_id
+	FROM room_members
+	WHERE room_id = $2 AND user_id != $3`
+
+	_, err := tx.ExecContext(ctx, query, message.ID, *message.RoomID, message.SenderID)
+	return err
+}
+
+// Edit replaces the content of a message sent by senderID, keeping the
+// previous content in message_versions. It returns the edited message.
+func (s *MessagesStore) Edit(ctx context.Context, messageID, senderID int64, content string) (*Message, error) {
+	message := Message{}
+	err := withTx(ctx, s.db, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+		defer cancel()
+
+		versionQuery := `
+		INSERT INTO message_versions (message_id, version, content)
+		SELECT id, version, content
+		FROM messages
+		WHERE id = $1 AND sender_id = $2 AND send_at IS NULL
+		FOR UPDATE`
+
+		res, err := tx.ExecContext(ctx, versionQuery, messageID, senderID)
+		if err != nil {
+			return err
+		}
+		rowsAffected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rowsAffected == 0 {
+			return ErrNotFound
+		}
+
+		query := `
+		UPDATE messages
+		SET content = $3, version = version + 1, edited = true, updated_at = NOW()
+		WHERE id = $1 AND sender_id = $2
+		RETURNING ` + messageColumns
+
+		return scanMessage(tx.QueryRowContext(ctx, query, messageID, senderID, content), &message)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	emptyAttachments := []string{}
+	message.Attachments = &emptyAttachments
+	messages := []Message{message}
+	if err := loadAttachments(ctx, s.db, messages); err != nil {
+		return nil, err
+	}
+
+	return &messages[0], nil
+}
+
+// GetVersions returns the previous contents of a message, oldest first.
+func (s *MessagesStore) GetVersions(ctx context.Context, messageID int64) ([]MessageVersion, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	query := `
+	SELECT id, message_id, content, version, created_at
+	FROM message_versions
+	WHERE message_id = $1
+	ORDER BY version ASC`
+
+	rows, err := s.db.QueryContext(ctx, query, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := []MessageVersion{}
+	for rows.Next() {
+		version := MessageVersion{}
+		err := rows.Scan(
+			&version.ID,
+			&version.MessageID,
+			&version.Content,
+			&version.Version,
+			&version.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+
+	return versions, rows.Err()
+}
+
+// Unsend deletes a message sent by senderID no longer than window ago and
+// leaves a tombstone in its place. It returns the tombstone along with the
+// paths of the message's attachments that can be removed from storage, which
+// is left to the caller.
+func (s *MessagesStore) Unsend(ctx context.Context, messageID, senderID int64, window time.Duration) (*MessageTombstone, []string, error) {
+	var tombstone *MessageTombstone
+	var paths []string
+	err := withTx(ctx, s.db, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+		defer cancel()
+
+		query := `
+		SELECT COALESCE(receiver_id, 0), room_id, created_at > NOW() - make_interval(secs => $3)
+		FROM messages
+		WHERE id = $1 AND sender_id = $2 AND send_at IS NULL
+		FOR UPDATE`
+
+		var receiverID int64
+		var roomID *int64
+		var withinWindow bool
+		err := tx.QueryRowContext(ctx, query, messageID, senderID, window.Seconds()).Scan(&receiverID, &roomID, &withinWindow)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return ErrNotFound
+			}
+			return err
+		}
+		if !withinWindow {
+			return ErrUnsendWindowExpired
+		}
+
+		tombstone, paths, err = tombstoneMessage(ctx, tx, messageID, senderID, receiverID, roomID)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return tombstone, paths, nil
+}
+
+// DeleteFromRoom deletes a message sent to roomID by anyone, for moderators,
+// and leaves a tombstone in its place. It returns the same as Unsend.
+func (s *MessagesStore) DeleteFromRoom(ctx context.Context, messageID, roomID int64) (*MessageTombstone, []string, error) {
+	var tombstone *MessageTombstone
+	var paths []string
+	err := withTx(ctx, s.db, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+		defer cancel()
+
+		var senderID int64
+		err := tx.QueryRowContext(ctx, `SELECT sender_id FROM messages WHERE id = $1 AND room_id = $2 AND send_at IS NULL FOR UPDATE`, messageID, roomID).Scan(&senderID)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return ErrNotFound
+			}
+			return err
+		}
+
+		tombstone, paths, err = tombstoneMessage(ctx, tx, messageID, senderID, 0, &roomID)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return tombstone, paths, nil
+}
+
+// tombstoneMessage deletes a message locked by the caller, records its
+// tombstone and returns it with the paths of the message's attachments that
+// can be removed from storage.
+func tombstoneMessage(ctx context.Context, tx *sql.Tx, messageID, senderID, receiverID int64, roomID *int64) (*MessageTombstone, []string, error) {
+	paths, err := deleteMessagesReturningPaths(ctx, tx, []int64{messageID})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	query := `
+	INSERT INTO message_tombstones (message_id, sender_id, receiver_id, room_id)
+	VALUES ($1, $2, NULLIF($3, 0), $4)
+	RETURNING id, message_id, sender_id, deleted_at`
+
+	tombstone := &MessageTombstone{ReceiverID: receiverID, RoomID: roomID}
+	err = tx.QueryRowContext(ctx, query, messageID, senderID, receiverID, roomID).Scan(
+		&tombstone.ID,
+		&tombstone.MessageID,
+		&tombstone.SenderID,
+		&tombstone.DeletedAt,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return tombstone, paths, nil
+}
+
+// AttachmentKeyPrefix is the prefix of the storage keys userID may upload
+// attachments under. Messages only carry attachments under their sender's
+// prefix.
+func AttachmentKeyPrefix(userID int64) string {
+	return fmt.Sprintf("user-%d-", userID)
+}
+
+// OwnsAttachment reports whether path is stored under the prefix of userID.
+func OwnsAttachment(userID int64, path string) bool {
+	return strings.HasPrefix(path, AttachmentKeyPrefix(userID))
+}
+
+// attachmentPaths returns the paths of the attachments of the given messages
+// that are stored under the prefix of the message's sender. Others were never
+// uploaded by the sender and must not be removed on their behalf.
+func attachmentPaths(ctx context.Context, tx *sql.Tx, messageIDs []int64) ([]string, error) {
+	query := `
+	SELECT a.path, m.sender_id
+	FROM attachments a
+	JOIN messages m ON m.id = a.message_id
+	WHERE a.message_id = ANY($1)`
+
+	rows, err := tx.QueryContext(ctx, query, pq.Array(messageIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	paths := []string{}
+	for rows.Next() {
+		var path string
+		var senderID int64
+		if err := rows.Scan(&path, &senderID); err != nil {
+			return nil, err
+		}
+		if OwnsAttachment(senderID, path) {
+			paths = append(paths, path)
+		}
+	}
+	return paths, rows.Err()
+}
+
+// unreferencedPaths returns the paths no attachment refers to anymore.
+func unreferencedPaths(ctx context.Context, tx *sql.Tx, paths []string) ([]string, error) {
+	if len(paths) == 0 {
+		return paths, nil
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT DISTINCT path FROM attachments WHERE path = ANY($1)`, pq.Array(paths))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	referenced := make(map[string]bool)
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, err
+		}
+		referenced[path] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	unreferenced := make([]string, 0, len(paths))
+	for _, path := range paths {
+		if !referenced[path] {
+			unreferenced = append(unreferenced, path)
+		}
+	}
+	return unreferenced, nil
+}
+
+const scheduledMessageColumns = `
+	id,
+	sender_id,
+	COALESCE(receiver_id, 0),
+	room_id,
+	content,
+	reply_to_id,
+	reply_to,
+	send_at,
+	expires_at,
+	created_at,
+	updated_at`
+
+func scanScheduledMessage(row interface{ Scan(...any) error }, message *Message, extra ...any) error {
+	dest := []any{
+		&message.ID,
+		&message.SenderID,
+		&message.ReceiverID,
+		&message.RoomID,
+		&message.Content,
+		&message.ReplyToID,
+		replySnapshot{&message.ReplyTo},
+		&message.SendAt,
+		&message.ExpiresAt,
+		&message.CreatedAt,
+		&message.UpdatedAt,
+	}
+	return row.Scan(append(dest, extra...)...)
+}
+
+// GetScheduled returns a page of the messages senderID has scheduled, the
+// soonest first.
+func (s *MessagesStore) GetScheduled(ctx context.Context, senderID int64, pagination *Pagination) (*[]Message, *CursorPage, error) {
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
 	defer cancel()
 
+	condition, orderBy, keysetArgs := pagination.keyset("send_at", "id", "ASC", 2)
 	query := `
-	SELECT 
-		id,
-		sender_id,
-		receiver_id,
-		content,
-		is_read,
-		is_delivered,
-		version,
-		edited,
-		created_at,
-		updated_at,
-		COUNT(*) OVER() AS total
+	SELECT ` + scheduledMessageColumns + `
 	FROM messages
-	WHERE (sender_id = $1 OR receiver_id = $1) AND is_delivered = $2
-	ORDER BY created_at ASC
-	LIMIT $3 OFFSET $4`
+	WHERE sender_id = $1 AND send_at IS NOT NULL
+	AND ` + condition + `
+	ORDER BY ` + orderBy + `
+	LIMIT $2`
 
-	rows, err := s.db.QueryContext(
-		ctx,
-		query,
-		userID,
-		false,
-		pagination.Limit,
-		pagination.CalculateOffset(),
-	)
+	args := append([]any{senderID, pagination.keysetLimit()}, keysetArgs...)
+	rows, err := s.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, 0, err
+		return nil, nil, err
 	}
 	defer rows.Close()
 
-	messages := make([]Message, 0, pagination.Limit)
-	messageMap := make(map[int64]*Message)
-	messageIDs := make([]int64, 0, len(messages))
-	total := 0
-
+	messages := make([]Message, 0, pagination.keysetLimit())
 	for rows.Next() {
 		message := Message{}
-		err := rows.Scan(
-			&message.ID,
-			&message.SenderID,
-			&message.ReceiverID,
-			&message.Content,
-			&message.IsRead,
-			&message.IsDelivered,
-			&message.Version,
-			&message.Edited,
-			&message.CreatedAt,
-			&message.UpdatedAt,
-			&total,
-		)
-		if err != nil {
-			return nil, 0, err
+		if err := scanScheduledMessage(rows, &message); err != nil {
+			return nil, nil, err
 		}
 
 		emptyAttachments := []string{}
 		message.Attachments = &emptyAttachments
-
 		messages = append(messages, message)
-		if _, exists := messageMap[message.ID]; !exists {
-			messageIDs = append(messageIDs, message.ID)
-		}
-		messageMap[message.ID] = &messages[len(messages)-1]
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, 0, err
+		return nil, nil, err
+	}
+
+	messages, page, err := cursorPage(pagination, messages, func(m Message) (Cursor, error) {
+		return NewCursor(*m.SendAt, m.ID)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := loadAttachments(ctx, s.db, messages); err != nil {
+		return nil, nil, err
+	}
+
+	if pagination.WithTotal {
+		total := 0
+		countQuery := `SELECT COUNT(*) FROM messages WHERE sender_id = $1 AND send_at IS NOT NULL`
+		if err := s.db.QueryRowContext(ctx, countQuery, senderID).Scan(&total); err != nil {
+			return nil, nil, err
+		}
+		page.Total = &total
+	}
+
+	return &messages, page, nil
+}
+
+// EditScheduled changes the content, the send time or both of a message
+// senderID has scheduled, a nil argument keeps the current value. No version
+// is kept since nobody has seen the message yet. A disappearing message keeps
+// its timer, counted from the new send time.
+func (s *MessagesStore) EditScheduled(ctx context.Context, messageID, senderID int64, content, sendAt *string) (*Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	query := `
+	UPDATE messages
+	SET content = COALESCE($3, content),
+		send_at = COALESCE($4::timestamptz, send_at),
+		expires_at = expires_at + (COALESCE($4::timestamptz, send_at) - send_at),
+		updated_at = NOW()
+	WHERE id = $1 AND sender_id = $2 AND send_at IS NOT NULL
+	RETURNING ` + scheduledMessageColumns
+
+	message := Message{}
+	err := scanScheduledMessage(s.db.QueryRowContext(ctx, query, messageID, senderID, content, sendAt), &message)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	emptyAttachments := []string{}
+	message.Attachments = &emptyAttachments
+	messages := []Message{message}
+	if err := loadAttachments(ctx, s.db, messages); err != nil {
+		return nil, err
+	}
+
+	return &messages[0], nil
+}
+
+// CancelScheduled deletes a message senderID has scheduled. Nobody has seen it,
+// so no tombstone is left. It returns the paths of the message's attachments
+// that can be removed from storage, which is left to the caller.
+func (s *MessagesStore) CancelScheduled(ctx context.Context, messageID, senderID int64) ([]string, error) {
+	var paths []string
+	err := withTx(ctx, s.db, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+		defer cancel()
+
+		var id int64
+		err := tx.QueryRowContext(ctx, `SELECT id FROM messages WHERE id = $1 AND sender_id = $2 AND send_at IS NOT NULL FOR UPDATE`, messageID, senderID).Scan(&id)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return ErrNotFound
+			}
+			return err
+		}
+
+		paths, err = deleteMessagesReturningPaths(ctx, tx, []int64{messageID})
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	if len(messages) > 0 {
-		attachmentsQuery := `
-			SELECT message_id, path
-			FROM attachments
-			WHERE message_id = ANY($1)
-			ORDER BY message_id, id`
+	return paths, nil
+}
+
+// DueMessages is what ReleaseDue did with the due messages it picked.
+type DueMessages struct {
+	// Released are the messages sent, in id order.
+	Released []Message
+	// Dropped counts the messages deleted because their sender may no longer
+	// send them: they left the room or the receiver is no longer a contact.
+	Dropped int
+	// Held counts the messages put off until their sender's room mute ends.
+	Held int
+	// Paths are the attachments of dropped messages that can be removed from
+	// storage, which is left to the caller.
+	Paths []string
+}
+
+// Count is the number of due messages handled.
+func (d *DueMessages) Count() int {
+	return len(d.Released) + d.Dropped + d.Held
+}
+
+// ReleaseDue handles up to limit scheduled messages whose send time has come.
+// Those their sender may still send are turned into regular messages, sent
+// now. The sender is checked again as things may have changed since the
+// message was scheduled: messages to rooms the sender left or to users no
+// longer their contacts are dropped, messages to rooms the sender is muted in
+// are held until the mute ends. Concurrent callers handle disjoint messages.
+func (s *MessagesStore) ReleaseDue(ctx context.Context, limit int) (*DueMessages, error) {
+	due := &DueMessages{Released: make([]Message, 0, limit), Paths: []string{}}
+	err := withTx(ctx, s.db, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+		defer cancel()
 
-		attachmentRows, err := s.db.QueryContext(ctx, attachmentsQuery, pq.Array(messageIDs))
+		pickQuery := `
+		SELECT
+			m.id,
+			CASE
+				WHEN m.room_id IS NOT NULL THEN NOT EXISTS (
+					SELECT 1 FROM room_members rm WHERE rm.room_id = m.room_id AND rm.user_id = m.sender_id
+				)
+				ELSE NOT EXISTS (
+					SELECT 1 FROM contacts c
+					WHERE (c.user_id = m.sender_id AND c.contact_id = m.receiver_id)
+					OR (c.user_id = m.receiver_id AND c.contact_id = m.sender_id)
+				)
+			END,
+			EXISTS (
+				SELECT 1 FROM room_members rm
+				WHERE rm.room_id = m.room_id AND rm.user_id = m.sender_id AND rm.muted_until > NOW()
+			)
+		FROM messages m
+		WHERE m.send_at <= NOW()
+		ORDER BY m.send_at ASC, m.id ASC
+		LIMIT $1
+		FOR UPDATE OF m SKIP LOCKED`
+
+		rows, err := tx.QueryContext(ctx, pickQuery, limit)
 		if err != nil {
-			return nil, 0, err
+			return err
+		}
+		defer rows.Close()
+
+		var releaseIDs, dropIDs, holdIDs []int64
+		for rows.Next() {
+			var id int64
+			var drop, hold bool
+			if err := rows.Scan(&id, &drop, &hold); err != nil {
+				return err
+			}
+			switch {
+			case drop:
+				dropIDs = append(dropIDs, id)
+			case hold:
+				holdIDs = append(holdIDs, id)
+			default:
+				releaseIDs = append(releaseIDs, id)
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		if len(dropIDs) > 0 {
+			due.Paths, err = deleteMessagesReturningPaths(ctx, tx, dropIDs)
+			if err != nil {
+				return err
+			}
+			due.Dropped = len(dropIDs)
 		}
-		defer attachmentRows.Close()
 
-		for attachmentRows.Next() {
-			var messageID int64
-			var path string
+		if len(holdIDs) > 0 {
+			holdQuery := `
+			UPDATE messages m
+			SET send_at = rm.muted_until, updated_at = NOW()
+			FROM room_members rm
+			WHERE m.id = ANY($1) AND rm.room_id = m.room_id AND rm.user_id = m.sender_id`
 
-			if err := attachmentRows.Scan(&messageID, &path); err != nil {
-				return nil, 0, err
+			if _, err := tx.ExecContext(ctx, holdQuery, pq.Array(holdIDs)); err != nil {
+				return err
 			}
+			due.Held = len(holdIDs)
+		}
+
+		if len(releaseIDs) == 0 {
+			return nil
+		}
 
-			if message, exists := messageMap[messageID]; exists {
-				*message.Attachments = append(*message.Attachments, path)
+		query := `
+		UPDATE messages
+		SET send_at = NULL, created_at = NOW(), updated_at = NOW()
+		WHERE id = ANY($1)
+		RETURNING ` + messageColumns
+
+		releasedRows, err := tx.QueryContext(ctx, query, pq.Array(releaseIDs))
+		if err != nil {
+			return err
+		}
+		defer releasedRows.Close()
+
+		for releasedRows.Next() {
+			message := Message{}
+			err := scanMessage(releasedRows, &message)
+			if err != nil {
+				return err
 			}
+
+			emptyAttachments := []string{}
+			message.Attachments = &emptyAttachments
+			due.Released = append(due.Released, message)
+		}
+		if err := releasedRows.Err(); err != nil {
+			return err
 		}
 
-		if err = attachmentRows.Err(); err != nil {
-			return nil, 0, err
+		for i := range due.Released {
+			if due.Released[i].RoomID == nil {
+				continue
+			}
+			if err := addRoomMessageDeliveries(ctx, tx, &due.Released[i]); err != nil {
+				return err
+			}
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return &messages, total, nil
+	if err := loadAttachments(ctx, s.db, due.Released); err != nil {
+		return nil, err
+	}
+	sort.Slice(due.Released, func(i, j int) bool { return due.Released[i].ID < due.Released[j].ID })
+
+	return due, nil
 }
 
-func (s *MessagesStore) Create(ctx context.Context, message *Message) error {
-	return withTx(ctx, s.db, func(tx *sql.Tx) error {
-		err := addMessage(ctx, tx, message)
+// PurgeExpired deletes up to limit messages whose disappearing timer ran out
+// or that were sent directly and went undelivered for longer than retention.
+// Replies to them lose their snapshot of the original. It returns the deleted
+// messages and the paths of their attachments that can be removed from
+// storage, which is left to the caller. Concurrent callers purge disjoint
+// messages.
+func (s *MessagesStore) PurgeExpired(ctx context.Context, retention time.Duration, limit int) ([]ExpiredMessage, []string, error) {
+	expired := make([]ExpiredMessage, 0, limit)
+	paths := []string{}
+	err := withTx(ctx, s.db, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+		defer cancel()
+
+		query := `
+		SELECT
+			m.id,
+			m.sender_id,
+			COALESCE(m.receiver_id, 0),
+			m.room_id,
+			CASE WHEN m.room_id IS NULL THEN NOT m.is_delivered
+			ELSE EXISTS (SELECT 1 FROM room_message_deliveries d WHERE d.message_id = m.id)
+			END
+		FROM messages m
+		WHERE m.send_at IS NULL AND (
+			m.expires_at <= NOW()
+			OR (m.room_id IS NULL AND m.is_delivered = false AND m.created_at <= NOW() - make_interval(secs => $1))
+		)
+		ORDER BY m.id ASC
+		LIMIT $2
+		FOR UPDATE OF m SKIP LOCKED`
+
+		rows, err := tx.QueryContext(ctx, query, retention.Seconds(), limit)
 		if err != nil {
 			return err
 		}
+		defer rows.Close()
 
-		if message.Attachments != nil && len(*message.Attachments) > 0 {
-			err = addAttachments(ctx, tx, message.ID, message.Attachments)
+		messageIDs := make([]int64, 0, limit)
+		for rows.Next() {
+			message := ExpiredMessage{}
+			err := rows.Scan(
+				&message.MessageID,
+				&message.SenderID,
+				&message.ReceiverID,
+				&message.RoomID,
+				&message.Undelivered,
+			)
 			if err != nil {
 				return err
 			}
+			expired = append(expired, message)
+			messageIDs = append(messageIDs, message.MessageID)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(messageIDs) == 0 {
+			return nil
 		}
 
-		return nil
+		paths, err = deleteMessagesReturningPaths(ctx, tx, messageIDs)
+		return err
 	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return expired, paths, nil
+}
+
+// GetTombstones returns up to limit tombstones of messages userID sent or
+// received, in person or through a room they are in, recorded after the
+// tombstone afterID, oldest first.
+func (s *MessagesStore) GetTombstones(ctx context.Context, userID, afterID int64, limit int) ([]MessageTombstone, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	query := `
+	SELECT id, message_id, sender_id, COALESCE(receiver_id, 0), room_id, deleted_at
+	FROM message_tombstones
+	WHERE id > $2 AND (
+		sender_id = $1 OR receiver_id = $1
+		OR room_id IN (SELECT room_id FROM room_members WHERE user_id = $1)
+	)
+	ORDER BY id ASC
+	LIMIT $3`
+
+	rows, err := s.db.QueryContext(ctx, query, userID, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tombstones := make([]MessageTombstone, 0, limit)
+	for rows.Next() {
+		tombstone := MessageTombstone{}
+		err := rows.Scan(
+			&tombstone.ID,
+			&tombstone.MessageID,
+			&tombstone.SenderID,
+			&tombstone.ReceiverID,
+			&tombstone.RoomID,
+			&tombstone.DeletedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		tombstones = append(tombstones, tombstone)
+	}
+
+	return tombstones, rows.Err()
+}
+
+// deleteMessagesReturningPaths deletes messages like deleteMessages and
+// returns the paths of their attachments that can be removed from storage:
+// those the senders uploaded that no other message still refers to.
+func deleteMessagesReturningPaths(ctx context.Context, tx *sql.Tx, messageIDs []int64) ([]string, error) {
+	paths, err := attachmentPaths(ctx, tx, messageIDs)
+	if err != nil {
+		return nil, err
+	}
+	if err := deleteMessages(ctx, tx, messageIDs); err != nil {
+		return nil, err
+	}
+	return unreferencedPaths(ctx, tx, paths)
 }
 
-func (s *MessagesStore) Delete(ctx context.Context, messageID int64) error {
+// deleteMessages deletes messages along with their versions, reactions and
+// attachments. Replies to them lose their snapshot of the original.
+func deleteMessages(ctx context.Context, tx *sql.Tx, messageIDs []int64) error {
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
 	defer cancel()
 
-	_, err := s.db.ExecContext(ctx, `DELETE FROM messages WHERE id = $1`, messageID)
+	queries := []string{
+		`UPDATE messages SET reply_to = NULL WHERE reply_to_id = ANY($1)`,
+		`DELETE FROM message_versions WHERE message_id = ANY($1)`,
+		`DELETE FROM reactions WHERE message_id = ANY($1)`,
+		`DELETE FROM attachments WHERE message_id = ANY($1)`,
+		`DELETE FROM messages WHERE id = ANY($1)`,
+	}
+	for _, query := range queries {
+		if _, err := tx.ExecContext(ctx, query, pq.Array(messageIDs)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deviceRetention is how long a device that stopped connecting still counts
+// as one of its user's devices.
+const deviceRetention = "30 days"
+
+// ackedByEveryDevice holds for the message m once every device of the user $1
+// seen within deviceRetention acknowledged it.
+const ackedByEveryDevice = `NOT EXISTS (
+	SELECT 1 FROM user_devices d
+	WHERE d.user_id = $1 AND d.last_seen_at > NOW() - INTERVAL '` + deviceRetention + `'
+	AND NOT EXISTS (
+		SELECT 1 FROM message_device_acks a
+		WHERE a.message_id = m.id AND a.user_id = $1 AND a.device_id = d.device_id
+	)
+)`
+
+// RegisterDevice records that userID is using deviceID, so messages are only
+// delivered to userID once deviceID acknowledged them too.
+func (s *MessagesStore) RegisterDevice(ctx context.Context, userID int64, deviceID string) error {
+	return withTx(ctx, s.db, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+		defer cancel()
+
+		return registerDevice(ctx, tx, userID, deviceID)
+	})
+}
+
+func registerDevice(ctx context.Context, tx *sql.Tx, userID int64, deviceID string) error {
+	query := `
+	INSERT INTO user_devices (user_id, device_id)
+	VALUES ($1, $2)
+	ON CONFLICT (user_id, device_id) DO UPDATE SET last_seen_at = NOW()`
+
+	_, err := tx.ExecContext(ctx, query, userID, deviceID)
 	return err
 }
 
+// MarkDelivered records that deviceID of receiverID acknowledged the given
+// messages and returns the ids newly acknowledged. Delivery is per device: a
+// message stays pending for receiverID until every device registered in the
+// last deviceRetention acknowledged it, only then is it flagged as delivered.
+// A room message is purged once every member it was pending for got it, the
+// paths of its attachments that can be removed from storage are returned and
+// their removal is left to the caller. An empty deviceID acknowledges the
+// messages for receiverID as a whole.
+func (s *MessagesStore) MarkDelivered(ctx context.Context, receiverID int64, deviceID string, messageIDs []int64) ([]int64, []string, error) {
+	ackedIDs := make([]int64, 0, len(messageIDs))
+	paths := []string{}
+	err := withTx(ctx, s.db, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+		defer cancel()
+
+		everyDevice := "true"
+		if deviceID != "" {
+			if err := registerDevice(ctx, tx, receiverID, deviceID); err != nil {
+				return err
+			}
+
+			ackQuery := `
+			INSERT INTO message_device_acks (message_id, user_id, device_id)
+			SELECT m.id, $1, $2
+			FROM messages m
+			WHERE m.id = ANY($3) AND m.send_at IS NULL AND (
+				(m.receiver_id = $1 AND m.is_delivered = false)
+				OR EXISTS (SELECT 1 FROM room_message_deliveries d WHERE d.message_id = m.id AND d.user_id = $1)
+			)
+			ON CONFLICT DO NOTHING
+			RETURNING message_id`
+
+			ids, err := queryIDs(ctx, tx, ackQuery, receiverID, deviceID, pq.Array(messageIDs))
+			if err != nil {
+				return err
+			}
+			ackedIDs = append(ackedIDs, ids...)
+			everyDevice = ackedByEveryDevice
+		}
+
+		directQuery := `
+		UPDATE messages m
+		SET is_delivered = true, updated_at = NOW()
+		WHERE m.receiver_id = $1 AND m.id = ANY($2) AND m.is_delivered = false AND m.send_at IS NULL
+		AND ` + everyDevice + `
+		RETURNING m.id`
+
+		directIDs, err := queryIDs(ctx, tx, directQuery, receiverID, pq.Array(messageIDs))
+		if err != nil {
+			return err
+		}
+
+		// Lock the room messages first so members acknowledging the same
+		// message at once see each other's deliveries go.
+		lockQuery := `
+		SELECT id FROM messages
+		WHERE id = ANY($1) AND room_id IS NOT NULL
+		ORDER BY id
+		FOR UPDATE`
+		if _, err := queryIDs(ctx, tx, lockQuery, pq.Array(messageIDs)); err != nil {
+			return err
+		}
+
+		roomQuery := `
+		DELETE FROM room_message_deliveries rd
+		USING messages m
+		WHERE m.id = rd.message_id AND rd.user_id = $1 AND rd.message_id = ANY($2)
+		AND ` + everyDevice + `
+		RETURNING rd.message_id`
+
+		roomIDs, err := queryIDs(ctx, tx, roomQuery, receiverID, pq.Array(messageIDs))
+		if err != nil {
+			return err
+		}
+
+		if deviceID == "" {
+			ackedIDs = append(append(ackedIDs, directIDs...), roomIDs...)
+		}
+
+		paths, err = purgeDeliveredRoomMessages(ctx, tx, roomIDs)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return ackedIDs, paths, nil
+}
+
+// purgeDeliveredRoomMessages deletes the room messages among messageIDs that
+// are no longer pending for any member and returns the paths of their
+// attachments that can be removed from storage. The messages must already be
+// locked, so members that got the same message at once see each other's
+// deliveries go and one of them purges it.
+func purgeDeliveredRoomMessages(ctx context.Context, tx *sql.Tx, messageIDs []int64) ([]string, error) {
+	if len(messageIDs) == 0 {
+		return []string{}, nil
+	}
+
+	query := `
+	SELECT m.id FROM messages m
+	WHERE m.id = ANY($1)
+	AND NOT EXISTS (SELECT 1 FROM room_message_deliveries d WHERE d.message_id = m.id)`
+
+	deliveredIDs, err := queryIDs(ctx, tx, query, pq.Array(messageIDs))
+	if err != nil {
+		return nil, err
+	}
+	if len(deliveredIDs) == 0 {
+		return []string{}, nil
+	}
+	return deleteMessagesReturningPaths(ctx, tx, deliveredIDs)
+}
+
+func queryIDs(ctx context.Context, tx *sql.Tx, query string, args ...any) ([]int64, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// MarkRead flags messages sent by senderID to readerID as read, either the ones
+// listed in messageIDs or, when upToID is set, every message up to and
+// including upToID. A read message is delivered as well. It returns the ids
+// that were actually updated.
+func (s *MessagesStore) MarkRead(ctx context.Context, readerID, senderID int64, messageIDs []int64, upToID int64) ([]int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	query := `
+	UPDATE messages
+	SET is_read = true, is_delivered = true, updated_at = NOW()
+	WHERE receiver_id = $1 AND sender_id = $2 AND is_read = false AND send_at IS NULL
+	AND (id = ANY($3) OR id <= $4)
+	RETURNING id`
+
+	rows, err := s.db.QueryContext(ctx, query, readerID, senderID, pq.Array(messageIDs), upToID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	readIDs := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		readIDs = append(readIDs, id)
+	}
+
+	return readIDs, rows.Err()
+}
+
+// loadAttachments fills in the attachment paths of messages, each message is
+// expected to have an empty, non nil Attachments slice.
+func loadAttachments(ctx context.Context, db *sql.DB, messages []Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	messageMap := make(map[int64]*Message, len(messages))
+	messageIDs := make([]int64, 0, len(messages))
+	for i := range messages {
+		if _, exists := messageMap[messages[i].ID]; !exists {
+			messageIDs = append(messageIDs, messages[i].ID)
+		}
+		messageMap[messages[i].ID] = &messages[i]
+	}
+
+	attachmentsQuery := `
+		SELECT message_id, path
+		FROM attachments
+		WHERE message_id = ANY($1)
+		ORDER BY message_id, id`
+
+	attachmentRows, err := db.QueryContext(ctx, attachmentsQuery, pq.Array(messageIDs))
+	if err != nil {
+		return err
+	}
+	defer attachmentRows.Close()
+
+	for attachmentRows.Next() {
+		var messageID int64
+		var path string
+
+		if err := attachmentRows.Scan(&messageID, &path); err != nil {
+			return err
+		}
+
+		if message, exists := messageMap[messageID]; exists {
+			*message.Attachments = append(*message.Attachments, path)
+		}
+	}
+
+	return attachmentRows.Err()
+}
+
 func addAttachments(ctx context.Context, tx *sql.Tx, messageID int64, attachments *[]string) error {
 	query := `
 		INSERT INTO attachments 
@@ -179,28 +1457,58 @@ func addAttachments(ctx context.Context, tx *sql.Tx, messageID int64, attachment
 }
 
 func addMessage(ctx context.Context, tx *sql.Tx, message *Message) error {
+	var replyTo any
+	if message.ReplyTo != nil {
+		snapshot, err := json.Marshal(message.ReplyTo)
+		if err != nil {
+			return err
+		}
+		replyTo = string(snapshot)
+	}
+
+	// The timer of the conversation, if on, starts when the message is sent.
 	query := `
 	INSERT INTO messages 
-		(sender_id, receiver_id, content, is_read, is_delivered, version, edited)
-	VALUES ($1, $2, $3, $4, $5, $6, $7)
-	RETURNING id, created_at, updated_at`
+		(sender_id, receiver_id, room_id, content, is_read, is_delivered, version, edited, reply_to_id, reply_to, send_at, client_message_id, expires_at)
+	VALUES ($1, NULLIF($2, 0), $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, (
+		SELECT COALESCE($11::timestamptz, NOW()) + make_interval(secs => t.message_ttl_seconds)
+		FROM (
+			SELECT message_ttl_seconds FROM rooms WHERE id = $3
+			UNION ALL
+			SELECT message_ttl_seconds FROM contacts
+			WHERE (user_id = $1 AND contact_id = $2) OR (user_id = $2 AND contact_id = $1)
+		) t
+		WHERE t.message_ttl_seconds > 0
+		LIMIT 1
+	))
+	ON CONFLICT (sender_id, client_message_id) WHERE client_message_id IS NOT NULL DO NOTHING
+	RETURNING id, expires_at, created_at, updated_at`
 
 	err := tx.QueryRowContext(
 		ctx,
 		query,
 		message.SenderID,
 		message.ReceiverID,
+		message.RoomID,
 		message.Content,
 		message.IsRead,
 		message.IsDelivered,
 		message.Version,
 		message.Edited,
+		message.ReplyToID,
+		replyTo,
+		message.SendAt,
+		message.ClientMessageID,
 	).Scan(
 		&message.ID,
+		&message.ExpiresAt,
 		&message.CreatedAt,
 		&message.UpdatedAt,
 	)
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrMessageAlreadyExists
+		}
 		return err
 	}
 
@@ -0,0 +1,50 @@
+package store
+
+import "testing"
+
+func TestOwnsAttachment(t *testing.T) {
+	tests := []struct {
+		name   string
+		userID int64
+		path   string
+		want   bool
+	}{
+		{"own key", 1, "user-1-photo.png", true},
+		{"own key, nested", 12, "user-12-2024/03/photo.png", true},
+		{"another user's key", 1, "user-2-photo.png", false},
+		{"user id sharing a prefix", 1, "user-12-photo.png", false},
+		{"user id sharing a suffix", 12, "user-2-photo.png", false},
+		{"prefix only", 1, "user-1-", true},
+		{"missing separator", 1, "user-1photo.png", false},
+		{"prefix not at the start", 1, "uploads/user-1-photo.png", false},
+		{"empty path", 1, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := OwnsAttachment(tt.userID, tt.path); got != tt.want {
+				t.Errorf("OwnsAttachment(%d, %q) = %v, want %v", tt.userID, tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDueMessagesCount(t *testing.T) {
+	tests := []struct {
+		name string
+		due  DueMessages
+		want int
+	}{
+		{"nothing due", DueMessages{}, 0},
+		{"released only", DueMessages{Released: make([]Message, 3)}, 3},
+		{"dropped and held", DueMessages{Dropped: 2, Held: 1}, 3},
+		{"paths are not messages", DueMessages{Dropped: 1, Paths: []string{"a", "b"}}, 1},
+		{"all of them", DueMessages{Released: make([]Message, 2), Dropped: 3, Held: 4}, 9},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.due.Count(); got != tt.want {
+				t.Errorf("Count() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
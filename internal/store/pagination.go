@@ -1,12 +1,28 @@
 package store
 
-import "math"
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
 
 type Pagination struct {
 	Limit         int    `json:"limit"`
 	Page          int    `json:"page"`
-	Sort          string `json:"sort"`
 	SortDirection string `json:"sort_direction"`
+	// After and Before select the page of a keyset listing that follows or
+	// precedes a cursor, at most one of them is set. Keyset listings ignore
+	// Page.
+	After  *Cursor `json:"after,omitempty"`
+	Before *Cursor `json:"before,omitempty"`
+	// WithTotal asks keyset listings to count all their rows, which they
+	// skip by default.
+	WithTotal bool `json:"with_total"`
 }
 
 func (p *Pagination) CalculateOffset() int {
@@ -21,3 +37,133 @@ type PaginatedResult struct {
 	Data  interface{} `json:"data"`
 	Total int         `json:"total"`
 }
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor is the position of a row in a listing ordered by (created_at, id),
+// or another timestamp in place of created_at. Clients only ever see it
+// encoded.
+type Cursor struct {
+	CreatedAt time.Time
+	ID        int64
+}
+
+func NewCursor(createdAt string, id int64) (Cursor, error) {
+	t, err := time.Parse(time.RFC3339Nano, createdAt)
+	if err != nil {
+		return Cursor{}, err
+	}
+	return Cursor{CreatedAt: t, ID: id}, nil
+}
+
+func (c Cursor) Encode() string {
+	raw := c.CreatedAt.UTC().Format(time.RFC3339Nano) + "," + strconv.FormatInt(c.ID, 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func DecodeCursor(encoded string) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	createdAt, id, ok := strings.Cut(string(raw), ",")
+	if !ok {
+		return nil, ErrInvalidCursor
+	}
+	t, err := time.Parse(time.RFC3339Nano, createdAt)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &Cursor{CreatedAt: t, ID: n}, nil
+}
+
+// CursorPage tells where a page of a keyset listing sits. Next and Prev are
+// empty when there is nothing on that side, Total is only set when asked for.
+type CursorPage struct {
+	Next  string
+	Prev  string
+	Total *int
+}
+
+// keyset returns the condition selecting the rows of p's page in a listing
+// ordered by (createdAtColumn, idColumn) in direction, and the ORDER BY to
+// fetch them with. The condition's args are numbered from argOffset+1. Pages
+// before a cursor are fetched backwards and put back in order by cursorPage.
+func (p *Pagination) keyset(createdAtColumn, idColumn, direction string, argOffset int) (string, string, []any) {
+	descending := direction == "DESC"
+	if p.Before != nil {
+		descending = !descending
+	}
+
+	order := "ASC"
+	if descending {
+		order = "DESC"
+	}
+	orderBy := fmt.Sprintf("%s %s, %s %s", createdAtColumn, order, idColumn, order)
+
+	cursor := p.After
+	if p.Before != nil {
+		cursor = p.Before
+	}
+	if cursor == nil {
+		return "TRUE", orderBy, nil
+	}
+
+	comparison := ">"
+	if descending {
+		comparison = "<"
+	}
+	condition := fmt.Sprintf("(%s, %s) %s ($%d, $%d)", createdAtColumn, idColumn, comparison, argOffset+1, argOffset+2)
+	return condition, orderBy, []any{cursor.CreatedAt, cursor.ID}
+}
+
+// keysetLimit is the number of rows to fetch for a page, one more than the
+// page holds to learn whether another one follows.
+func (p *Pagination) keysetLimit() int {
+	return p.Limit + 1
+}
+
+// cursorPage trims the extra row fetched by keysetLimit, restores the order
+// of a page fetched backwards and returns the cursors on either side of it.
+func cursorPage[T any](p *Pagination, rows []T, cursorOf func(T) (Cursor, error)) ([]T, *CursorPage, error) {
+	hasMore := len(rows) > p.Limit
+	if hasMore {
+		rows = rows[:p.Limit]
+	}
+	if p.Before != nil {
+		slices.Reverse(rows)
+	}
+
+	page := &CursorPage{}
+	if len(rows) == 0 {
+		if p.Before != nil {
+			page.Next = p.Before.Encode()
+		}
+		if p.After != nil {
+			page.Prev = p.After.Encode()
+		}
+		return rows, page, nil
+	}
+
+	first, err := cursorOf(rows[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	last, err := cursorOf(rows[len(rows)-1])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Going backwards there is always the page we came from after this one.
+	if p.Before != nil || hasMore {
+		page.Next = last.Encode()
+	}
+	if p.After != nil || (p.Before != nil && hasMore) {
+		page.Prev = first.Encode()
+	}
+	return rows, page, nil
+}
@@ -0,0 +1,311 @@
+package store
+
+import (
+	"encoding/base64"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestCursorEncodeDecode(t *testing.T) {
+	cursor := Cursor{CreatedAt: time.Date(2024, 3, 9, 14, 5, 6, 123456000, time.UTC), ID: 42}
+
+	decoded, err := DecodeCursor(cursor.Encode())
+	if err != nil {
+		t.Fatalf("DecodeCursor returned error: %v", err)
+	}
+	if !decoded.CreatedAt.Equal(cursor.CreatedAt) || decoded.ID != cursor.ID {
+		t.Errorf("DecodeCursor(Encode(%v)) = %v", cursor, *decoded)
+	}
+}
+
+func TestCursorEncodeNormalizesToUTC(t *testing.T) {
+	local := time.Date(2024, 3, 9, 16, 5, 6, 0, time.FixedZone("CEST", 2*60*60))
+	cursor := Cursor{CreatedAt: local, ID: 1}
+
+	decoded, err := DecodeCursor(cursor.Encode())
+	if err != nil {
+		t.Fatalf("DecodeCursor returned error: %v", err)
+	}
+	if !decoded.CreatedAt.Equal(local) || decoded.CreatedAt.Location() != time.UTC {
+		t.Errorf("DecodeCursor(Encode(%v)).CreatedAt = %v", local, decoded.CreatedAt)
+	}
+}
+
+func TestDecodeCursorInvalid(t *testing.T) {
+	tests := map[string]string{
+		"empty":          "",
+		"not base64":     "!!!",
+		"padded base64":  "MjAyNC0wMy0wOVQxNDowNTowNlosNDI=",
+		"no separator":   base64Cursor("2024-03-09T14:05:06Z"),
+		"bad time":       base64Cursor("yesterday,42"),
+		"bad id":         base64Cursor("2024-03-09T14:05:06Z,forty-two"),
+		"missing id":     base64Cursor("2024-03-09T14:05:06Z,"),
+		"trailing comma": base64Cursor("2024-03-09T14:05:06Z,42,"),
+	}
+
+	for name, encoded := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := DecodeCursor(encoded); err != ErrInvalidCursor {
+				t.Errorf("DecodeCursor(%q) error = %v, want %v", encoded, err, ErrInvalidCursor)
+			}
+		})
+	}
+}
+
+func base64Cursor(raw string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func TestNewCursor(t *testing.T) {
+	cursor, err := NewCursor("2024-03-09T14:05:06.5Z", 7)
+	if err != nil {
+		t.Fatalf("NewCursor returned error: %v", err)
+	}
+	if cursor.ID != 7 || cursor.CreatedAt.Nanosecond() != 500000000 {
+		t.Errorf("NewCursor = %v", cursor)
+	}
+
+	if _, err := NewCursor("not a time", 7); err == nil {
+		t.Error("NewCursor accepted an invalid time")
+	}
+}
+
+// row stands in for a listed record, its cursor is (CreatedAt, ID).
+type row struct {
+	ID int64
+}
+
+var cursorBase = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func cursorOfRow(r row) (Cursor, error) {
+	return cursorAt(r.ID), nil
+}
+
+func cursorAt(id int64) Cursor {
+	return Cursor{CreatedAt: cursorBase.Add(time.Duration(id) * time.Minute), ID: id}
+}
+
+func rowsOf(ids ...int64) []row {
+	rows := make([]row, len(ids))
+	for i, id := range ids {
+		rows[i] = row{ID: id}
+	}
+	return rows
+}
+
+func idsOf(rows []row) []int64 {
+	ids := make([]int64, len(rows))
+	for i, r := range rows {
+		ids[i] = r.ID
+	}
+	return ids
+}
+
+func encoded(id int64) string {
+	return cursorAt(id).Encode()
+}
+
+func ptr(c Cursor) *Cursor {
+	return &c
+}
+
+func TestCursorPage(t *testing.T) {
+	tests := []struct {
+		name       string
+		pagination Pagination
+		// fetched is what the query returned, in query order and with up
+		// to keysetLimit rows.
+		fetched  []row
+		wantIDs  []int64
+		wantNext string
+		wantPrev string
+	}{
+		{
+			name:       "first page with more",
+			pagination: Pagination{Limit: 2},
+			fetched:    rowsOf(1, 2, 3),
+			wantIDs:    []int64{1, 2},
+			wantNext:   encoded(2),
+		},
+		{
+			name:       "only page",
+			pagination: Pagination{Limit: 2},
+			fetched:    rowsOf(1, 2),
+			wantIDs:    []int64{1, 2},
+		},
+		{
+			name:       "after with more",
+			pagination: Pagination{Limit: 2, After: ptr(cursorAt(2))},
+			fetched:    rowsOf(3, 4, 5),
+			wantIDs:    []int64{3, 4},
+			wantNext:   encoded(4),
+			wantPrev:   encoded(3),
+		},
+		{
+			name:       "after, last page",
+			pagination: Pagination{Limit: 2, After: ptr(cursorAt(4))},
+			fetched:    rowsOf(5),
+			wantIDs:    []int64{5},
+			wantPrev:   encoded(5),
+		},
+		{
+			name:       "before with more is reversed",
+			pagination: Pagination{Limit: 2, Before: ptr(cursorAt(5))},
+			fetched:    rowsOf(4, 3, 2),
+			wantIDs:    []int64{3, 4},
+			wantNext:   encoded(4),
+			wantPrev:   encoded(3),
+		},
+		{
+			name:       "before, first page",
+			pagination: Pagination{Limit: 2, Before: ptr(cursorAt(3))},
+			fetched:    rowsOf(2, 1),
+			wantIDs:    []int64{1, 2},
+			wantNext:   encoded(2),
+		},
+		{
+			name:       "empty listing",
+			pagination: Pagination{Limit: 2},
+			fetched:    rowsOf(),
+			wantIDs:    []int64{},
+		},
+		{
+			name:       "empty page after",
+			pagination: Pagination{Limit: 2, After: ptr(cursorAt(9))},
+			fetched:    rowsOf(),
+			wantIDs:    []int64{},
+			wantPrev:   encoded(9),
+		},
+		{
+			name:       "empty page before",
+			pagination: Pagination{Limit: 2, Before: ptr(cursorAt(1))},
+			fetched:    rowsOf(),
+			wantIDs:    []int64{},
+			wantNext:   encoded(1),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rows, page, err := cursorPage(&tc.pagination, tc.fetched, cursorOfRow)
+			if err != nil {
+				t.Fatalf("cursorPage returned error: %v", err)
+			}
+			if got := idsOf(rows); !slices.Equal(got, tc.wantIDs) {
+				t.Errorf("rows = %v, want %v", got, tc.wantIDs)
+			}
+			if page.Next != tc.wantNext {
+				t.Errorf("Next = %q, want %q", page.Next, tc.wantNext)
+			}
+			if page.Prev != tc.wantPrev {
+				t.Errorf("Prev = %q, want %q", page.Prev, tc.wantPrev)
+			}
+			if page.Total != nil {
+				t.Errorf("Total = %d, want nil", *page.Total)
+			}
+		})
+	}
+}
+
+// TestCursorPageWalk pages through a listing forwards and back again with the
+// cursors cursorPage hands out, each step returning the expected rows.
+func TestCursorPageWalk(t *testing.T) {
+	all := rowsOf(1, 2, 3, 4, 5)
+	// fetch plays the part of the query built by keyset.
+	fetch := func(p *Pagination) []row {
+		var rows []row
+		switch {
+		case p.After != nil:
+			for _, r := range all {
+				if r.ID > p.After.ID {
+					rows = append(rows, r)
+				}
+			}
+		case p.Before != nil:
+			for _, r := range slices.Backward(all) {
+				if r.ID < p.Before.ID {
+					rows = append(rows, r)
+				}
+			}
+		default:
+			rows = slices.Clone(all)
+		}
+		if len(rows) > p.keysetLimit() {
+			rows = rows[:p.keysetLimit()]
+		}
+		return rows
+	}
+	page := func(p *Pagination) ([]int64, *CursorPage) {
+		rows, page, err := cursorPage(p, fetch(p), cursorOfRow)
+		if err != nil {
+			t.Fatalf("cursorPage returned error: %v", err)
+		}
+		return idsOf(rows), page
+	}
+	decode := func(encoded string) *Cursor {
+		cursor, err := DecodeCursor(encoded)
+		if err != nil {
+			t.Fatalf("DecodeCursor(%q) returned error: %v", encoded, err)
+		}
+		return cursor
+	}
+
+	want := [][]int64{{1, 2}, {3, 4}, {5}}
+	ids, current := page(&Pagination{Limit: 2})
+	for i := range want {
+		if !slices.Equal(ids, want[i]) {
+			t.Fatalf("page %d forwards = %v, want %v", i, ids, want[i])
+		}
+		if i == len(want)-1 {
+			break
+		}
+		ids, current = page(&Pagination{Limit: 2, After: decode(current.Next)})
+	}
+	if current.Next != "" {
+		t.Errorf("last page has a next cursor")
+	}
+
+	for i := len(want) - 2; i >= 0; i-- {
+		ids, current = page(&Pagination{Limit: 2, Before: decode(current.Prev)})
+		if !slices.Equal(ids, want[i]) {
+			t.Fatalf("page %d backwards = %v, want %v", i, ids, want[i])
+		}
+	}
+	if current.Prev != "" {
+		t.Errorf("first page has a prev cursor")
+	}
+}
+
+func TestKeyset(t *testing.T) {
+	after := ptr(cursorAt(3))
+	tests := []struct {
+		name          string
+		pagination    Pagination
+		direction     string
+		wantCondition string
+		wantOrderBy   string
+		wantArgs      int
+	}{
+		{"no cursor", Pagination{}, "ASC", "TRUE", "created_at ASC, id ASC", 0},
+		{"after ascending", Pagination{After: after}, "ASC", "(created_at, id) > ($3, $4)", "created_at ASC, id ASC", 2},
+		{"after descending", Pagination{After: after}, "DESC", "(created_at, id) < ($3, $4)", "created_at DESC, id DESC", 2},
+		{"before ascending", Pagination{Before: after}, "ASC", "(created_at, id) < ($3, $4)", "created_at DESC, id DESC", 2},
+		{"before descending", Pagination{Before: after}, "DESC", "(created_at, id) > ($3, $4)", "created_at ASC, id ASC", 2},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			condition, orderBy, args := tc.pagination.keyset("created_at", "id", tc.direction, 2)
+			if condition != tc.wantCondition {
+				t.Errorf("condition = %q, want %q", condition, tc.wantCondition)
+			}
+			if orderBy != tc.wantOrderBy {
+				t.Errorf("orderBy = %q, want %q", orderBy, tc.wantOrderBy)
+			}
+			if len(args) != tc.wantArgs {
+				t.Errorf("got %d args, want %d", len(args), tc.wantArgs)
+			}
+		})
+	}
+}
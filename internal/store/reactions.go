@@ -0,0 +1,94 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/9thDuck/chat_go.git/internal/domain"
+	"github.com/lib/pq"
+)
+
+type Reaction domain.Reaction
+
+type ReactionsStore struct {
+	db *sql.DB
+}
+
+func (s *ReactionsStore) Add(ctx context.Context, messageID, userID int64, emoji string) (*Reaction, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	query := `
+	INSERT INTO reactions (message_id, user_id, emoji)
+	VALUES ($1, $2, $3)
+	RETURNING id, created_at`
+
+	reaction := Reaction{MessageID: messageID, UserID: userID, Emoji: emoji}
+	err := s.db.QueryRowContext(ctx, query, messageID, userID, emoji).Scan(&reaction.ID, &reaction.CreatedAt)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			switch pqErr.Code {
+			case PQ_CODE_UNIQUE_CONSTRAINT_VIOLATION:
+				return nil, ErrReactionAlreadyExists
+			case PQ_CODE_FOREIGN_KEY_CONSTRAINT_VIOLATION:
+				return nil, ErrNotFound
+			}
+		}
+		return nil, err
+	}
+
+	return &reaction, nil
+}
+
+func (s *ReactionsStore) Remove(ctx context.Context, messageID, userID int64, emoji string) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	query := `DELETE FROM reactions WHERE message_id = $1 AND user_id = $2 AND emoji = $3`
+
+	res, err := s.db.ExecContext(ctx, query, messageID, userID, emoji)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrReactionNotFound
+	}
+
+	return nil
+}
+
+// CountByMessages returns the reaction counts of each of the given messages,
+// most used emoji first. Messages nobody reacted to are left out.
+func (s *ReactionsStore) CountByMessages(ctx context.Context, messageIDs []int64) (map[int64][]domain.ReactionCount, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	query := `
+	SELECT message_id, emoji, COUNT(*)
+	FROM reactions
+	WHERE message_id = ANY($1)
+	GROUP BY message_id, emoji
+	ORDER BY message_id, COUNT(*) DESC, MIN(created_at)`
+
+	rows, err := s.db.QueryContext(ctx, query, pq.Array(messageIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[int64][]domain.ReactionCount)
+	for rows.Next() {
+		var messageID int64
+		count := domain.ReactionCount{}
+		if err := rows.Scan(&messageID, &count.Emoji, &count.Count); err != nil {
+			return nil, err
+		}
+		counts[messageID] = append(counts[messageID], count)
+	}
+
+	return counts, rows.Err()
+}
@@ -7,6 +7,13 @@ import (
 	"github.com/9thDuck/chat_go.git/internal/domain"
 )
 
+// Names of the roles seeded in the roles table.
+const (
+	ROLE_USER      = "user"
+	ROLE_MODERATOR = "moderator"
+	ROLE_ADMIN     = "admin"
+)
+
 type RolesStore struct {
 	db *sql.DB
 }
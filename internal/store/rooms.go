@@ -0,0 +1,429 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/9thDuck/chat_go.git/internal/domain"
+	"github.com/lib/pq"
+)
+
+type Room domain.Room
+
+type RoomMember domain.RoomMember
+
+// Roles a member can hold in a room, in order of precedence.
+const (
+	ROOM_ROLE_MEMBER    = "member"
+	ROOM_ROLE_MODERATOR = "moderator"
+	ROOM_ROLE_OWNER     = "owner"
+)
+
+var roomRoleLevels = map[string]int{
+	ROOM_ROLE_MEMBER:    1,
+	ROOM_ROLE_MODERATOR: 2,
+	ROOM_ROLE_OWNER:     3,
+}
+
+// HasRoomRole reports whether role is at least as high as required.
+func HasRoomRole(role, required string) bool {
+	return roomRoleLevels[role] >= roomRoleLevels[required]
+}
+
+type RoomsStore struct {
+	db *sql.DB
+}
+
+// Create inserts room and makes every user in room.MemberIDs a member, the
+// owner included with the owner role.
+func (s *RoomsStore) Create(ctx context.Context, room *Room) error {
+	return withTx(ctx, s.db, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+		defer cancel()
+
+		query := `
+		INSERT INTO rooms (name, owner_id)
+		VALUES ($1, $2)
+		RETURNING id, created_at, updated_at`
+
+		err := tx.QueryRowContext(ctx, query, room.Name, room.OwnerID).Scan(
+			&room.ID,
+			&room.CreatedAt,
+			&room.UpdatedAt,
+		)
+		if err != nil {
+			return err
+		}
+
+		membersQuery := `
+		INSERT INTO room_members (room_id, user_id, role)
+		SELECT $1, u.id, CASE WHEN u.id = $3 THEN 'owner' ELSE 'member' END
+		FROM unnest($2::bigint[]) AS u(id)
+		ON CONFLICT DO NOTHING`
+
+		_, err = tx.ExecContext(ctx, membersQuery, room.ID, pq.Array(room.MemberIDs), room.OwnerID)
+		if err != nil {
+			if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == PQ_CODE_FOREIGN_KEY_CONSTRAINT_VIOLATION {
+				return ErrNotFound
+			}
+			return err
+		}
+
+		return nil
+	})
+}
+
+func (s *RoomsStore) GetByID(ctx context.Context, roomID int64) (*Room, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	query := `
+	SELECT r.id, r.name, r.owner_id, r.message_ttl_seconds, r.created_at, r.updated_at,
+	COALESCE(array_agg(m.user_id ORDER BY m.joined_at) FILTER (WHERE m.user_id IS NOT NULL), '{}'),
+	COALESCE(array_agg(m.user_id ORDER BY m.joined_at) FILTER (WHERE m.role = 'moderator'), '{}')
+	FROM rooms r
+	LEFT JOIN room_members m ON m.room_id = r.id
+	WHERE r.id = $1
+	GROUP BY r.id`
+
+	room := Room{}
+	err := s.db.QueryRowContext(ctx, query, roomID).Scan(
+		&room.ID,
+		&room.Name,
+		&room.OwnerID,
+		&room.MessageTTLSeconds,
+		&room.CreatedAt,
+		&room.UpdatedAt,
+		pq.Array(&room.MemberIDs),
+		pq.Array(&room.ModeratorIDs),
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return &room, nil
+}
+
+// GetByMember returns a page of the rooms userID is a member of.
+func (s *RoomsStore) GetByMember(ctx context.Context, userID int64, pagination *Pagination) (*[]Room, *CursorPage, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	condition, orderBy, keysetArgs := pagination.keyset("r.created_at", "r.id", pagination.SortDirection, 2)
+	query := `
+	SELECT r.id, r.name, r.owner_id, r.message_ttl_seconds, r.created_at, r.updated_at,
+	(SELECT array_agg(m.user_id ORDER BY m.joined_at) FROM room_members m WHERE m.room_id = r.id),
+	(SELECT COALESCE(array_agg(m.user_id ORDER BY m.joined_at), '{}') FROM room_members m WHERE m.room_id = r.id AND m.role = 'moderator')
+	FROM rooms r
+	JOIN room_members me ON me.room_id = r.id AND me.user_id = $1
+	WHERE ` + condition + `
+	ORDER BY ` + orderBy + `
+	LIMIT $2`
+
+	args := append([]any{userID, pagination.keysetLimit()}, keysetArgs...)
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	rooms := make([]Room, 0, pagination.keysetLimit())
+
+	for rows.Next() {
+		room := Room{}
+		err := rows.Scan(
+			&room.ID,
+			&room.Name,
+			&room.OwnerID,
+			&room.MessageTTLSeconds,
+			&room.CreatedAt,
+			&room.UpdatedAt,
+			pq.Array(&room.MemberIDs),
+			pq.Array(&room.ModeratorIDs),
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+		rooms = append(rooms, room)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	rooms, page, err := cursorPage(pagination, rooms, func(r Room) (Cursor, error) {
+		return NewCursor(r.CreatedAt, r.ID)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if pagination.WithTotal {
+		total := 0
+		countQuery := `SELECT COUNT(*) FROM room_members WHERE user_id = $1`
+		if err := s.db.QueryRowContext(ctx, countQuery, userID).Scan(&total); err != nil {
+			return nil, nil, err
+		}
+		page.Total = &total
+	}
+
+	return &rooms, page, nil
+}
+
+func (s *RoomsStore) Update(ctx context.Context, room *Room) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	query := `
+	UPDATE rooms
+	SET name = $2, updated_at = NOW()
+	WHERE id = $1
+	RETURNING updated_at`
+
+	err := s.db.QueryRowContext(ctx, query, room.ID, room.Name).Scan(&room.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	return nil
+}
+
+// SetMessageTTL sets the disappearing message timer of a room, 0 turns it off.
+// Messages already sent keep their timer.
+func (s *RoomsStore) SetMessageTTL(ctx context.Context, room *Room, ttl time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	query := `
+	UPDATE rooms
+	SET message_ttl_seconds = $2, updated_at = NOW()
+	WHERE id = $1
+	RETURNING message_ttl_seconds, updated_at`
+
+	err := s.db.QueryRowContext(ctx, query, room.ID, int(ttl.Seconds())).Scan(&room.MessageTTLSeconds, &room.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Delete removes a room along with its members and every message sent to it.
+func (s *RoomsStore) Delete(ctx context.Context, roomID int64) error {
+	return withTx(ctx, s.db, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+		defer cancel()
+
+		messageIDs, err := queryIDs(ctx, tx, `SELECT id FROM messages WHERE room_id = $1`, roomID)
+		if err != nil {
+			return err
+		}
+		if err := deleteMessages(ctx, tx, messageIDs); err != nil {
+			return err
+		}
+
+		res, err := tx.ExecContext(ctx, `DELETE FROM rooms WHERE id = $1`, roomID)
+		if err != nil {
+			return err
+		}
+		rowsAffected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rowsAffected == 0 {
+			return ErrNotFound
+		}
+
+		return nil
+	})
+}
+
+func (s *RoomsStore) GetMemberIDs(ctx context.Context, roomID int64) ([]int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `SELECT user_id FROM room_members WHERE room_id = $1 ORDER BY joined_at`, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	memberIDs := []int64{}
+	for rows.Next() {
+		var memberID int64
+		if err := rows.Scan(&memberID); err != nil {
+			return nil, err
+		}
+		memberIDs = append(memberIDs, memberID)
+	}
+
+	return memberIDs, rows.Err()
+}
+
+func (s *RoomsStore) IsMember(ctx context.Context, roomID, userID int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	query := `SELECT EXISTS (SELECT 1 FROM room_members WHERE room_id = $1 AND user_id = $2)`
+
+	var isMember bool
+	err := s.db.QueryRowContext(ctx, query, roomID, userID).Scan(&isMember)
+	return isMember, err
+}
+
+func (s *RoomsStore) AddMember(ctx context.Context, roomID, userID int64) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, `INSERT INTO room_members (room_id, user_id) VALUES ($1, $2)`, roomID, userID)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			switch pqErr.Code {
+			case PQ_CODE_UNIQUE_CONSTRAINT_VIOLATION:
+				return ErrRoomMemberAlreadyExists
+			case PQ_CODE_FOREIGN_KEY_CONSTRAINT_VIOLATION:
+				return ErrNotFound
+			}
+		}
+		return err
+	}
+
+	return nil
+}
+
+// RemoveMember takes userID out of a room. Messages still pending for them
+// are no longer waited on, the ones nobody else is waiting for are purged. It
+// returns the paths of their attachments that can be removed from storage,
+// which is left to the caller.
+func (s *RoomsStore) RemoveMember(ctx context.Context, roomID, userID int64) ([]string, error) {
+	var paths []string
+	err := withTx(ctx, s.db, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+		defer cancel()
+
+		res, err := tx.ExecContext(ctx, `DELETE FROM room_members WHERE room_id = $1 AND user_id = $2`, roomID, userID)
+		if err != nil {
+			return err
+		}
+		rowsAffected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rowsAffected == 0 {
+			return ErrRoomMemberNotFound
+		}
+
+		// Lock the messages first, like MarkDelivered, so members acknowledging
+		// them meanwhile see this member's deliveries go.
+		lockQuery := `
+		SELECT m.id FROM messages m
+		JOIN room_message_deliveries d ON d.message_id = m.id
+		WHERE m.room_id = $1 AND d.user_id = $2
+		ORDER BY m.id
+		FOR UPDATE OF m`
+		if _, err := queryIDs(ctx, tx, lockQuery, roomID, userID); err != nil {
+			return err
+		}
+
+		query := `
+		DELETE FROM room_message_deliveries d
+		USING messages m
+		WHERE d.message_id = m.id AND m.room_id = $1 AND d.user_id = $2
+		RETURNING d.message_id`
+
+		messageIDs, err := queryIDs(ctx, tx, query, roomID, userID)
+		if err != nil {
+			return err
+		}
+
+		paths, err = purgeDeliveredRoomMessages(ctx, tx, messageIDs)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return paths, nil
+}
+
+const roomMemberColumns = `room_id, user_id, role, muted_until, COALESCE(muted_until > NOW(), false), joined_at`
+
+func scanRoomMember(row *sql.Row) (*RoomMember, error) {
+	member := RoomMember{}
+	err := row.Scan(
+		&member.RoomID,
+		&member.UserID,
+		&member.Role,
+		&member.MutedUntil,
+		&member.IsMuted,
+		&member.JoinedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrRoomMemberNotFound
+		}
+		return nil, err
+	}
+	return &member, nil
+}
+
+func (s *RoomsStore) GetMember(ctx context.Context, roomID, userID int64) (*RoomMember, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	query := `SELECT ` + roomMemberColumns + ` FROM room_members WHERE room_id = $1 AND user_id = $2`
+
+	return scanRoomMember(s.db.QueryRowContext(ctx, query, roomID, userID))
+}
+
+// SetMemberRole changes the role of a member other than the owner, the owner
+// role itself cannot be handed out this way.
+func (s *RoomsStore) SetMemberRole(ctx context.Context, roomID, userID int64, role string) (*RoomMember, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	query := `
+	UPDATE room_members
+	SET role = $3
+	WHERE room_id = $1 AND user_id = $2 AND role <> 'owner'
+	RETURNING ` + roomMemberColumns
+
+	return scanRoomMember(s.db.QueryRowContext(ctx, query, roomID, userID, role))
+}
+
+// Mute stops userID from sending messages to a room for duration.
+func (s *RoomsStore) Mute(ctx context.Context, roomID, userID int64, duration time.Duration) (*RoomMember, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	query := `
+	UPDATE room_members
+	SET muted_until = NOW() + make_interval(secs => $3)
+	WHERE room_id = $1 AND user_id = $2
+	RETURNING ` + roomMemberColumns
+
+	return scanRoomMember(s.db.QueryRowContext(ctx, query, roomID, userID, duration.Seconds()))
+}
+
+func (s *RoomsStore) Unmute(ctx context.Context, roomID, userID int64) (*RoomMember, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	query := `
+	UPDATE room_members
+	SET muted_until = NULL
+	WHERE room_id = $1 AND user_id = $2
+	RETURNING ` + roomMemberColumns
+
+	return scanRoomMember(s.db.QueryRowContext(ctx, query, roomID, userID))
+}
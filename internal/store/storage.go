@@ -15,6 +15,7 @@ type Storage struct {
 		GetByID(ctx context.Context, userP *User) error
 		GetUserWithEncryptionKey(ctx context.Context, userID int64, encryptionKeyID string) (*UserWithEncryptionKey, error)
 		UpdateUserDataByID(ctx context.Context, user *User) error
+		UpdateRole(ctx context.Context, userID, roleID int64) error
 		Search(ctx context.Context, userID int64, searchTerm string, pagination *Pagination) (*[]UserDataForAddContact, int, error)
 	}
 
@@ -23,24 +24,68 @@ type Storage struct {
 	}
 
 	Contacts interface {
-		Get(ctx context.Context, userID int64, pagination *Pagination) (*[]int64, int, error)
-		Search(ctx context.Context, userID int64, searchTerm string, pagination *Pagination) (*[]int64, int, error)
+		Get(ctx context.Context, userID int64, pagination *Pagination) (*[]int64, *CursorPage, error)
+		Search(ctx context.Context, userID int64, searchTerm string, pagination *Pagination) (*[]int64, *CursorPage, error)
+		GetAllIDs(ctx context.Context, userID int64) ([]int64, error)
 		GetContactExists(ctx context.Context, userID, contactID int64) (bool, error)
 		Delete(ctx context.Context, userID, contactID int64) error
+		SetMessageTTL(ctx context.Context, userID, contactID int64, ttl time.Duration) error
 	}
 
 	ContactRequests interface {
-		Create(ctx context.Context, senderID, receiverID int64) error
-		Get(ctx context.Context, senderID int64, pagination *Pagination) (*[]ContactRequest, int, error)
-		Accept(ctx context.Context, senderID, receiverID int64) error
-		Reject(ctx context.Context, senderID, receiverID int64) error
-		Delete(ctx context.Context, senderID, receiverID int64) error
+		Create(ctx context.Context, senderID, receiverID int64) (*ContactRequest, error)
+		Get(ctx context.Context, userID int64, pagination *Pagination) (*[]ContactRequest, *CursorPage, error)
+		Accept(ctx context.Context, senderID, receiverID int64) (*ContactRequest, error)
+		Reject(ctx context.Context, senderID, receiverID int64) (*ContactRequest, error)
+		Delete(ctx context.Context, senderID, receiverID int64) (*ContactRequest, error)
 	}
 
 	Messages interface {
-		Get(ctx context.Context, userID int64, pagination *Pagination) (*[]Message, int, error)
+		Get(ctx context.Context, userID int64, pagination *Pagination) (*[]Message, *CursorPage, error)
+		GetPending(ctx context.Context, receiverID int64, deviceID string, afterID int64, limit int) (*[]Message, error)
+		GetByID(ctx context.Context, messageID int64) (*Message, error)
+		GetByRoom(ctx context.Context, roomID int64, pagination *Pagination) (*[]Message, *CursorPage, error)
+		GetVersions(ctx context.Context, messageID int64) ([]MessageVersion, error)
 		Create(ctx context.Context, message *Message) error
-		Delete(ctx context.Context, messageID int64) error
+		Edit(ctx context.Context, messageID, senderID int64, content string) (*Message, error)
+		Unsend(ctx context.Context, messageID, senderID int64, window time.Duration) (*MessageTombstone, []string, error)
+		DeleteFromRoom(ctx context.Context, messageID, roomID int64) (*MessageTombstone, []string, error)
+		GetTombstones(ctx context.Context, userID, afterID int64, limit int) ([]MessageTombstone, error)
+		RegisterDevice(ctx context.Context, userID int64, deviceID string) error
+		MarkDelivered(ctx context.Context, receiverID int64, deviceID string, messageIDs []int64) ([]int64, []string, error)
+		MarkRead(ctx context.Context, readerID, senderID int64, messageIDs []int64, upToID int64) ([]int64, error)
+		GetScheduled(ctx context.Context, senderID int64, pagination *Pagination) (*[]Message, *CursorPage, error)
+		EditScheduled(ctx context.Context, messageID, senderID int64, content, sendAt *string) (*Message, error)
+		CancelScheduled(ctx context.Context, messageID, senderID int64) ([]string, error)
+		ReleaseDue(ctx context.Context, limit int) (*DueMessages, error)
+		PurgeExpired(ctx context.Context, retention time.Duration, limit int) ([]ExpiredMessage, []string, error)
+	}
+
+	Conversations interface {
+		Get(ctx context.Context, userID int64, pagination *Pagination) (*[]Conversation, *CursorPage, error)
+	}
+
+	Rooms interface {
+		Create(ctx context.Context, room *Room) error
+		GetByID(ctx context.Context, roomID int64) (*Room, error)
+		GetByMember(ctx context.Context, userID int64, pagination *Pagination) (*[]Room, *CursorPage, error)
+		Update(ctx context.Context, room *Room) error
+		SetMessageTTL(ctx context.Context, room *Room, ttl time.Duration) error
+		Delete(ctx context.Context, roomID int64) error
+		GetMemberIDs(ctx context.Context, roomID int64) ([]int64, error)
+		IsMember(ctx context.Context, roomID, userID int64) (bool, error)
+		AddMember(ctx context.Context, roomID, userID int64) error
+		RemoveMember(ctx context.Context, roomID, userID int64) ([]string, error)
+		GetMember(ctx context.Context, roomID, userID int64) (*RoomMember, error)
+		SetMemberRole(ctx context.Context, roomID, userID int64, role string) (*RoomMember, error)
+		Mute(ctx context.Context, roomID, userID int64, duration time.Duration) (*RoomMember, error)
+		Unmute(ctx context.Context, roomID, userID int64) (*RoomMember, error)
+	}
+
+	Reactions interface {
+		Add(ctx context.Context, messageID, userID int64, emoji string) (*Reaction, error)
+		Remove(ctx context.Context, messageID, userID int64, emoji string) error
+		CountByMessages(ctx context.Context, messageIDs []int64) (map[int64][]domain.ReactionCount, error)
 	}
 
 	EncryptionKeys interface {
@@ -57,6 +102,9 @@ func NewStorage(db *sql.DB) Storage {
 		Contacts:        &ContactsStore{db},
 		ContactRequests: &ContactRequestsStore{db},
 		Messages:        &MessagesStore{db},
+		Rooms:           &RoomsStore{db},
+		Conversations:   &ConversationsStore{db},
+		Reactions:       &ReactionsStore{db},
 		EncryptionKeys:  &EncryptionKeysStore{db},
 	}
 }
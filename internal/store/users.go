@@ -27,38 +27,40 @@ type UserDataForAddContact struct {
 }
 
 type UserWithEncryptionKey struct {
-	ID              int64        `json:"id"`
-	Username        string       `json:"username"`
-	Email           string       `json:"email"`
-	HashedPassword  string       `json:"-"`
-	PublicKey       string       `json:"publicKey"`
-	FirstName       string       `json:"firstName"`
-	LastName        string       `json:"lastName"`
-	EncryptionKeyID string       `json:"-"`
-	EncryptionKey   string       `json:"encryptionKey"`
-	ProfilePic      string       `json:"profilePic"`
-	RoleID          int64        `json:"roleId"`
-	Role            *domain.Role `json:"role"`
-	CreatedAt       string       `json:"createdAt"`
-	UpdatedAt       string       `json:"updatedAt"`
+	ID                  int64        `json:"id"`
+	Username            string       `json:"username"`
+	Email               string       `json:"email"`
+	HashedPassword      string       `json:"-"`
+	PublicKey           string       `json:"publicKey"`
+	FirstName           string       `json:"firstName"`
+	LastName            string       `json:"lastName"`
+	EncryptionKeyID     string       `json:"-"`
+	EncryptionKey       string       `json:"encryptionKey"`
+	ProfilePic          string       `json:"profilePic"`
+	RoleID              int64        `json:"roleId"`
+	Role                *domain.Role `json:"role"`
+	ReadReceiptsEnabled bool         `json:"readReceiptsEnabled"`
+	CreatedAt           string       `json:"createdAt"`
+	UpdatedAt           string       `json:"updatedAt"`
 }
 
 func NewUserWithEncryptionKey(user *User, encryptionKey *EncryptionKey) *UserWithEncryptionKey {
 	return &UserWithEncryptionKey{
-		ID:              user.ID,
-		Username:        user.Username,
-		Email:           user.Email,
-		HashedPassword:  user.HashedPassword,
-		PublicKey:       user.PublicKey,
-		FirstName:       user.FirstName,
-		LastName:        user.LastName,
-		EncryptionKeyID: encryptionKey.ID,
-		EncryptionKey:   encryptionKey.Key,
-		ProfilePic:      user.ProfilePic,
-		RoleID:          user.RoleID,
-		Role:            user.Role,
-		CreatedAt:       user.CreatedAt,
-		UpdatedAt:       user.UpdatedAt,
+		ID:                  user.ID,
+		Username:            user.Username,
+		Email:               user.Email,
+		HashedPassword:      user.HashedPassword,
+		PublicKey:           user.PublicKey,
+		FirstName:           user.FirstName,
+		LastName:            user.LastName,
+		EncryptionKeyID:     encryptionKey.ID,
+		EncryptionKey:       encryptionKey.Key,
+		ProfilePic:          user.ProfilePic,
+		RoleID:              user.RoleID,
+		Role:                user.Role,
+		ReadReceiptsEnabled: user.ReadReceiptsEnabled,
+		CreatedAt:           user.CreatedAt,
+		UpdatedAt:           user.UpdatedAt,
 	}
 }
 
@@ -104,7 +106,7 @@ func (s *UsersStore) Create(ctx context.Context, user *User, encryptionKey *Encr
 				(SELECT r.id FROM roles r WHERE r.name = $7),
 				$8
 			)
-			RETURNING id, role_id, created_at, updated_at, public_key
+			RETURNING id, role_id, created_at, updated_at, public_key, read_receipts_enabled
 		)
 		SELECT 
 			iu.id, 
@@ -114,7 +116,8 @@ func (s *UsersStore) Create(ctx context.Context, user *User, encryptionKey *Encr
 			r.description,
 			iu.created_at, 
 			iu.updated_at,
-			iu.public_key
+			iu.public_key,
+			iu.read_receipts_enabled
 		FROM inserted_user iu
 		JOIN roles r ON r.id = iu.role_id;`
 
@@ -138,6 +141,7 @@ func (s *UsersStore) Create(ctx context.Context, user *User, encryptionKey *Encr
 		&user.CreatedAt,
 		&user.UpdatedAt,
 		&user.PublicKey,
+		&user.ReadReceiptsEnabled,
 	)
 
 	if err != nil {
@@ -173,7 +177,8 @@ func (s *UsersStore) GetByID(ctx context.Context, user *User) error {
 	defer cancel()
 	query := `
 		SELECT 
-		u.username, u.email, u.hashed_password, u.first_name, u.last_name, u.public_key, u.role_id, u.created_at, u.updated_at,
+		u.username, u.email, u.hashed_password, u.first_name, u.last_name, u.public_key, u.role_id,
+		u.read_receipts_enabled, u.created_at, u.updated_at,
 		r.id, r.name, r.level, r.description
 		FROM 
 		users u JOIN roles r ON u.role_id = r.id
@@ -191,6 +196,7 @@ func (s *UsersStore) GetByID(ctx context.Context, user *User) error {
 		&user.LastName,
 		&user.PublicKey,
 		&user.RoleID,
+		&user.ReadReceiptsEnabled,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 		&user.Role.ID,
@@ -258,7 +264,8 @@ func (s *UsersStore) GetByEmail(ctx context.Context, email string) (*User, error
 
 	query :=
 		`SELECT
-		 u.id, u.username, u.hashed_password, u.first_name, u.last_name, u.public_key, u.role_id, u.created_at, u.updated_at,
+		 u.id, u.username, u.hashed_password, u.first_name, u.last_name, u.public_key, u.role_id,
+		 u.read_receipts_enabled, u.created_at, u.updated_at,
 		 r.id, r.name, r.description, r.level
 		 FROM users u JOIN roles r ON r.id = u.role_id
 		 WHERE u.email = $1`
@@ -280,6 +287,7 @@ func (s *UsersStore) GetByEmail(ctx context.Context, email string) (*User, error
 		&user.LastName,
 		&user.PublicKey,
 		&user.RoleID,
+		&user.ReadReceiptsEnabled,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 		&user.Role.ID,
@@ -301,8 +309,8 @@ func (s *UsersStore) UpdateUserDataByID(ctx context.Context, user *User) error {
 
 	query := `
 		UPDATE users 
-		SET first_name = $1, last_name = $2, profile_pic = $3 
-		WHERE id = $4`
+		SET first_name = $1, last_name = $2, profile_pic = $3, read_receipts_enabled = $4
+		WHERE id = $5`
 
 	_, err := s.db.ExecContext(
 		ctx,
@@ -310,6 +318,7 @@ func (s *UsersStore) UpdateUserDataByID(ctx context.Context, user *User) error {
 		user.FirstName,
 		user.LastName,
 		user.ProfilePic,
+		user.ReadReceiptsEnabled,
 		user.ID,
 	)
 	if err != nil {
@@ -318,6 +327,29 @@ func (s *UsersStore) UpdateUserDataByID(ctx context.Context, user *User) error {
 	return nil
 }
 
+func (s *UsersStore) UpdateRole(ctx context.Context, userID, roleID int64) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	query := `
+		UPDATE users
+		SET role_id = $1, updated_at = NOW()
+		WHERE id = $2`
+
+	res, err := s.db.ExecContext(ctx, query, roleID, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
 func (s *UsersStore) Search(ctx context.Context, userID int64, searchTerm string, pagination *Pagination) (*[]UserDataForAddContact, int, error) {
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
 	defer cancel()
@@ -405,7 +437,8 @@ func (s *UsersStore) GetUserWithEncryptionKey(ctx context.Context, userID int64,
 
 	query := `
 		SELECT 
-		u.username, u.email, u.hashed_password, u.first_name, u.last_name, u.public_key, u.role_id, u.created_at, u.updated_at,
+		u.username, u.email, u.hashed_password, u.first_name, u.last_name, u.public_key, u.role_id,
+		u.read_receipts_enabled, u.created_at, u.updated_at,
 		r.id, r.name, r.level, r.description, ek.key
 		FROM 
 		users u 
@@ -432,6 +465,7 @@ func (s *UsersStore) GetUserWithEncryptionKey(ctx context.Context, userID int64,
 		&userWithKey.LastName,
 		&userWithKey.PublicKey,
 		&userWithKey.RoleID,
+		&userWithKey.ReadReceiptsEnabled,
 		&userWithKey.CreatedAt,
 		&userWithKey.UpdatedAt,
 		&userWithKey.Role.ID,